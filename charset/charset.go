@@ -0,0 +1,137 @@
+package charset
+
+import (
+	"bytes"
+	"goodnews/urlnorm"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const UTF8 = "utf-8"
+
+// Set on the transcoded responses, so the scraper can record the charset of the page
+const DetectedHeader = "X-Detected-Charset"
+
+// Declared charset is only trusted if the detector finds it at least this plausible, as some sites declare utf-8 or windows-1252 for windows-1251 pages
+const minDeclaredConfidence = 10
+
+var metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.-]+)`)
+
+// Detect returns the charset of the HTML page. The override wins, then valid UTF-8, then the charset declared in <meta> or in the Content-Type header if the detector agrees, and the detector's best guess otherwise
+func Detect(body []byte, contentType, override string) string {
+	if override != "" {
+		return normalize(override)
+	}
+
+	if utf8.Valid(body) {
+		return UTF8
+	}
+
+	var declared []string
+	head := body
+	if len(head) > 2048 {
+		head = head[:2048]
+	}
+	if match := metaCharset.FindSubmatch(head); match != nil {
+		declared = append(declared, normalize(string(match[1])))
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		declared = append(declared, normalize(params["charset"]))
+	}
+
+	results, err := chardet.NewHtmlDetector().DetectAll(body)
+	if err != nil || len(results) == 0 {
+		if len(declared) > 0 {
+			return declared[0]
+		}
+		return UTF8
+	}
+
+	for _, name := range declared {
+		for _, result := range results {
+			if normalize(result.Charset) == name && result.Confidence >= minDeclaredConfidence {
+				return name
+			}
+		}
+	}
+
+	return normalize(results[0].Charset)
+}
+
+// ToUTF8 transcodes the body from the charset
+func ToUTF8(body []byte, name string) ([]byte, error) {
+	if name == UTF8 {
+		return body, nil
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return enc.NewDecoder().Bytes(body)
+}
+
+// Canonical name of the charset, e.g. windows-1251 for cp1251
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if enc, err := htmlindex.Get(name); err == nil {
+		if canonical, err := htmlindex.Name(enc); err == nil {
+			return canonical
+		}
+	}
+	return name
+}
+
+// Transport transcodes the HTML responses to UTF-8 with the charset from Detect, so the collector and the selectors always get UTF-8
+type Transport struct {
+	Base http.RoundTripper
+	// Charset per host (as in urlnorm.Host), for the sites where the detection fails
+	Overrides map[string]string
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.Contains(strings.ToLower(contentType), "html") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	name := Detect(body, contentType, t.Overrides[urlnorm.Host(req.URL.String())])
+	transcoded, err := ToUTF8(body, name)
+	if err != nil {
+		log.Printf("Error transcoding %s from %s: %v. Proceeding with the original body", req.URL, name, err)
+		transcoded = body
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(transcoded))
+	resp.ContentLength = int64(len(transcoded))
+	resp.Header.Del("Content-Length")
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header.Set(DetectedHeader, name)
+
+	return resp, nil
+}
@@ -0,0 +1,107 @@
+package charset
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const page = `<html><head><meta charset="windows-1251"><title>Волонтёры спасли щенка</title></head><body>
+<p>Волонтёры приюта для бездомных животных нашли в лесу щенка, который три дня провёл без еды.</p>
+<p>Щенка отвезли в ветеринарную клинику, после лечения он нашёл новых хозяев.</p>
+</body></html>`
+
+func windows1251(t *testing.T, s string) []byte {
+	encoded, err := charmap.Windows1251.NewEncoder().String(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(encoded)
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		override    string
+		want        string
+	}{
+		{
+			name:        "valid utf-8 wins over the declared charset",
+			body:        []byte(page),
+			contentType: "text/html; charset=windows-1251",
+			want:        UTF8,
+		},
+		{
+			name:        "declared windows-1251",
+			body:        windows1251(t, page),
+			contentType: "text/html",
+			want:        "windows-1251",
+		},
+		{
+			name:        "declared utf-8 on a windows-1251 page",
+			body:        windows1251(t, strings.Replace(page, `<meta charset="windows-1251">`, "", 1)),
+			contentType: "text/html; charset=utf-8",
+			want:        "windows-1251",
+		},
+		{
+			name:     "override",
+			body:     []byte(page),
+			override: " CP1251 ",
+			want:     "windows-1251",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.body, tt.contentType, tt.override); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	got, err := ToUTF8(windows1251(t, page), "windows-1251")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != page {
+		t.Errorf("got %s, want %s", got, page)
+	}
+
+	if _, err := ToUTF8([]byte(page), "no-such-charset"); err == nil {
+		t.Error("got no error for an unknown charset")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(windows1251(t, page))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != page {
+		t.Errorf("got %s, want %s", body, page)
+	}
+	if got, want := resp.Header.Get(DetectedHeader), "windows-1251"; got != want {
+		t.Errorf("got charset %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,142 @@
+package classifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"goodnews/nlp"
+	"math"
+	"os"
+	"sort"
+)
+
+// Title is short but tells the most about the topic
+const titleWeight = 3
+
+type Document struct {
+	Label, Title string
+	Text         []string
+}
+
+// Model is a multinomial naive Bayes classifier with Laplace smoothing over the stems of the title and the text
+type Model struct {
+	Docs       int                       `json:"docs"`
+	LabelDocs  map[string]int            `json:"label_docs"`
+	LabelTerms map[string]int            `json:"label_terms"`
+	TermCounts map[string]map[string]int `json:"term_counts"`
+	Vocabulary int                       `json:"vocabulary"`
+}
+
+func Train(docs []Document) (*Model, error) {
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no labelled documents to train on")
+	}
+
+	m := &Model{
+		LabelDocs:  make(map[string]int),
+		LabelTerms: make(map[string]int),
+		TermCounts: make(map[string]map[string]int),
+	}
+	vocabulary := make(map[string]bool)
+
+	for _, doc := range docs {
+		if doc.Label == "" {
+			continue
+		}
+		m.Docs++
+		m.LabelDocs[doc.Label]++
+		if m.TermCounts[doc.Label] == nil {
+			m.TermCounts[doc.Label] = make(map[string]int)
+		}
+
+		for term, count := range terms(doc.Title, doc.Text) {
+			m.TermCounts[doc.Label][term] += count
+			m.LabelTerms[doc.Label] += count
+			vocabulary[term] = true
+		}
+	}
+
+	if len(m.LabelDocs) < 2 {
+		return nil, fmt.Errorf("at least 2 labels are needed to train the classifier, got %d", len(m.LabelDocs))
+	}
+
+	m.Vocabulary = len(vocabulary)
+	return m, nil
+}
+
+// Predict returns the most probable label and its probability
+func (m *Model) Predict(title string, text []string) (string, float64) {
+	docTerms := terms(title, text)
+	logProbs := make(map[string]float64)
+
+	for label, labelDocs := range m.LabelDocs {
+		logProb := math.Log(float64(labelDocs) / float64(m.Docs))
+		denominator := float64(m.LabelTerms[label] + m.Vocabulary)
+
+		for term, count := range docTerms {
+			logProb += float64(count) * math.Log(float64(m.TermCounts[label][term]+1)/denominator)
+		}
+		logProbs[label] = logProb
+	}
+
+	labels := make([]string, 0, len(logProbs))
+	for label := range logProbs {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	best := ""
+	for _, label := range labels {
+		if best == "" || logProbs[label] > logProbs[best] {
+			best = label
+		}
+	}
+	if best == "" {
+		return "", 0
+	}
+
+	// Softmax over the log probabilities
+	sum := 0.0
+	for _, logProb := range logProbs {
+		sum += math.Exp(logProb - logProbs[best])
+	}
+
+	return best, 1 / sum
+}
+
+func (m *Model) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func terms(title string, text []string) map[string]int {
+	counts := make(map[string]int)
+
+	for _, term := range nlp.Terms(title) {
+		counts[term] += titleWeight
+	}
+	for _, paragraph := range text {
+		for _, term := range nlp.Terms(paragraph) {
+			counts[term]++
+		}
+	}
+
+	return counts
+}
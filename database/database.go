@@ -1,197 +1,274 @@
 package database
 
 import (
+	"bytes"
+	"compress/gzip"
 	"database/sql"
-	"encoding/json"
-	"goodnews/external"
+	"fmt"
+	"goodnews/nlp"
 	"goodnews/scraping"
-	"log"
+	"goodnews/urlnorm"
+	"io"
 	"sort"
+	"strings"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-func InitDB(dryRun bool, filePath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", filePath)
-
-	if !dryRun {
-		createTableSQL := `
-            CREATE TABLE IF NOT EXISTS news_items (
-                id INTEGER PRIMARY KEY AUTOINCREMENT,
-                url TEXT,
-                category TEXT,
-                posted TEXT,
-                title TEXT,
-                image TEXT,
-                text TEXT,
-                p1 TEXT,
-                item_was_sent BOOLEAN
-            );
-        `
-
-		_, err = db.Exec(createTableSQL)
-		if err != nil {
-			log.Fatal(err)
-		}
-		log.Println("Database setup completed")
-	}
+// Store keeps the news items and their processing state. SQLiteStore is used for the real runs, MemoryStore for the dry runs and tests
+type Store interface {
+	// ItemSent reports whether the item stored with the url, or with its canonical form as an alias, was sent to any destination
+	ItemSent(url string) (bool, error)
+	// StoredUrl returns the url of the item stored with the url or any of the aliases of the item, which UpsertItem updates, or an empty string
+	StoredUrl(item scraping.NewsItem) (string, error)
+	// UpsertItem stores the new item with its aliases in one statement, so overlapping runs can't create duplicate rows.
+	// An item with the same url is updated unless it was sent to any destination, then it is skipped
+	UpsertItem(item scraping.NewsItem) (UpsertResult, error)
+	// InsertRejectedItem keeps the item which failed the page check or the validation with the reasons for review. Only the latest rejection of each url is kept
+	InsertRejectedItem(rejected scraping.RejectedItem) error
+	// DeleteRejectedItem deletes the rejection of the url, once an item with it is stored
+	DeleteRejectedItem(url string) error
+	// RecentFingerprints returns the fingerprints of the items posted after since which are not duplicates themselves, ordered by ID
+	RecentFingerprints(since time.Time) ([]ItemFingerprint, error)
+	// UnsentItems returns the items posted after since which are not duplicates and were not processed yet or have pending deliveries.
+	// The older ones are never sent, even if the thresholds or filters which kept them back change
+	UnsentItems(since time.Time) ([]scraping.NewsItem, error)
+	// LabelledItems returns the items with the category set by a mapping rule, to train the classifier on
+	LabelledItems() ([]scraping.NewsItem, error)
+	// UpdateFilterRule stores the rules which made the filter decisions for the item. The row is left as is if they did not change
+	UpdateFilterRule(id int, filterRule string) error
+	// UpdateImageCheck stores the result of the image check apart from the scraped image and images
+	UpdateImageCheck(id int, check ImageCheck) error
+	// Deliveries returns the deliveries of the item to each destination, ordered by the destination
+	Deliveries(id int) ([]Delivery, error)
+	// SaveDelivery creates or replaces the delivery of the item to its destination and releases its claim
+	SaveDelivery(delivery Delivery) error
+	// AddDelivery creates the delivery unless the item already has one to the destination
+	AddDelivery(delivery Delivery) error
+	// ClaimDelivery atomically marks the pending delivery as sending until the lease expires and returns it. ok is false if it is not pending,
+	// e.g. claimed by another instance
+	ClaimDelivery(id int, destination string, lease time.Duration) (delivery Delivery, ok bool, err error)
+	// ExpireClaims marks the deliveries which are still sending after their lease as failed and returns them.
+	// The process which claimed them died, maybe after the message was posted, so they are not retried
+	ExpireClaims() ([]Delivery, error)
+	// Translation returns the stored translation of the item to the language, ok is false if there is none
+	Translation(id int, lang string) (translation Translation, ok bool, err error)
+	SaveTranslation(id int, translation Translation) error
+	// SaveSnapshot stores the compressed page of the article fetch
+	SaveSnapshot(snapshot scraping.Snapshot) error
+	// Snapshots returns the snapshots of the source fetched after since, the oldest first
+	Snapshots(source string, since time.Time) ([]scraping.Snapshot, error)
+	// PruneSnapshots deletes the snapshots fetched before the time and returns their number
+	PruneSnapshots(before time.Time) (int, error)
+	// Search returns up to limit items matching all the words of the query in any form, the most relevant first
+	Search(query string, limit int) ([]scraping.NewsItem, error)
+	Close() error
+}
 
-	if err != nil {
-		return nil, err
-	}
+// Statuses of UpsertResult
+const (
+	Inserted = "new"
+	Updated  = "updated"
+	Skipped  = "skipped"
+)
 
-	return db, nil
+type UpsertResult struct {
+	// 0 if skipped
+	Id     int
+	Status string
 }
 
-func CheckAndInsertItem(dryRun bool, db *sql.DB, item scraping.NewsItem, newsAgeDays int) error {
-	if !dryRun {
-		query := "SELECT COUNT(*) FROM news_items WHERE url = ?"
-		var count int
-		err := db.QueryRow(query, item.Url).Scan(&count)
-		if err != nil {
-			return err
-		}
+// Statuses of Delivery
+const (
+	DeliveryPending = "pending"
+	// Claimed by a run which is sending it
+	DeliverySending = "sending"
+	DeliverySent    = "sent"
+	// No more attempts are made
+	DeliveryFailed = "failed"
+)
 
-		currentTime := time.Now()
+// Delivery is the state of sending the item to one destination
+type Delivery struct {
+	ItemId      int
+	Destination string
+	Status      string
+	Attempts    int
+	LastError   string
+	// ID of the message returned by Telegram
+	MessageId string
+	// Layout of NewsItem.Posted, empty if not sent
+	SentAt string
+}
 
-		postedTime, err := time.Parse("02-01-2006 15:04:05", item.Posted)
-		if err != nil {
-			return err
-		}
+// ImageCheck is the image and images which passed the check before sending, and its status from the imaging package
+type ImageCheck struct {
+	Image  string
+	Images []string
+	Status string
+}
 
-		daysDiff := currentTime.Sub(postedTime).Hours() / 24
+type ItemFingerprint struct {
+	Id          int
+	Fingerprint uint64
+}
 
-		if count > 0 {
-			log.Printf("Item with URL '%s' already exists in the database.", item.Url)
-			return nil
-		}
+type Translation struct {
+	Language, Category, Title string
+	Text                      []string
+}
 
-		if count == 0 && daysDiff <= float64(newsAgeDays) {
-			textJSON, err := json.Marshal(item.Text)
-			if err != nil {
-				return err
-			}
+func postedAfter(posted string, since time.Time) bool {
+	postedTime, err := scraping.ParsePosted(posted)
+	return err == nil && postedTime.After(since)
+}
 
-			insertQuery := `
-		INSERT INTO news_items (url, category, posted, title, image, text, p1, item_was_sent)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`
-			_, err = db.Exec(insertQuery, item.Url, item.Category, item.Posted, item.Title, item.Image, textJSON, item.Text[0], false)
-			if err != nil {
-				return err
-			}
+func postedItems(items []scraping.NewsItem, since time.Time) []scraping.NewsItem {
+	var posted []scraping.NewsItem
+	for _, item := range items {
+		if postedAfter(item.Posted, since) {
+			posted = append(posted, item)
+		}
+	}
+	return posted
+}
 
-			log.Printf("Item with URL '%s' inserted into the database.", item.Url)
+// Columns of the upsert, in the order of its arguments
+var upsertColumns = []string{"url", "category", "normalized_category", "posted", "title", "image", "text", "p1", "author", "images", "source", "fingerprint", "duplicate_of", "sentiment", "filter_rule", "category_source", "locations", "language", "charset"}
+
+// Returns the INSERT ... ON CONFLICT statement with the placeholders of the driver. An unsent item gets the new content, but stays a duplicate if it was one.
+// The statement returns the ID and the number of updates of the row, and no row if the item was already sent to any destination
+func upsertQuery(driver string) string {
+	var placeholders, updates []string
+	for _, column := range upsertColumns {
+		placeholders = append(placeholders, "?")
+		if column != "url" && column != "duplicate_of" {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", column, column))
 		}
-	} else {
-		log.Printf("DRY-RUN: Item with URL '%s' inserted into the database.", item.Url)
 	}
-	return nil
+
+	return rebind(driver, fmt.Sprintf(`
+		INSERT INTO news_items (%s) VALUES (%s)
+		ON CONFLICT (url) DO UPDATE SET %s, updates = news_items.updates + 1
+		WHERE NOT EXISTS (SELECT 1 FROM deliveries WHERE item_id = news_items.id AND status = 'sent')
+		RETURNING id, updates
+	`, strings.Join(upsertColumns, ", "), strings.Join(placeholders, ", "), strings.Join(updates, ", ")))
 }
 
-func ProcessUnsentItems(dryRun bool, db *sql.DB, reqSleepMs int) error {
-	if !dryRun {
-		tx, err := db.Begin()
+// Returns the canonical forms the item is deduplicated by, stored in url_aliases
+func itemAliases(item scraping.NewsItem) []string {
+	return urlnorm.Unique(append(append([]string{}, item.Aliases...), item.Url))
+}
 
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback()
+// *sql.DB or *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
 
-		query := "SELECT id, category, posted, url, title, image, text, p1 FROM news_items WHERE item_was_sent = false"
-		rows, err := tx.Query(query)
-		if err != nil {
-			return err
-		}
-		defer rows.Close()
+// Returns the url of the item stored with the url or under any of the aliases, the oldest one if several, or an empty string
+func storedUrl(q queryRower, driver, url string, aliases []string) (string, error) {
+	placeholders := []string{}
+	args := []interface{}{url}
+	for _, alias := range aliases {
+		placeholders = append(placeholders, "?")
+		args = append(args, alias)
+	}
+
+	var stored string
+	err := q.QueryRow(rebind(driver, "SELECT url FROM news_items WHERE url = ? OR id IN (SELECT item_id FROM url_aliases WHERE url IN ("+strings.Join(placeholders, ", ")+")) ORDER BY id LIMIT 1"), args...).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return stored, err
+}
 
-		var unsentItems []scraping.NewsItem
+// Selects the sent deliveries of the item stored with the url or its canonical form as an alias
+const sentItemQuery = "SELECT EXISTS (SELECT 1 FROM deliveries WHERE status = 'sent' AND item_id IN (SELECT id FROM news_items WHERE url = ? UNION SELECT item_id FROM url_aliases WHERE url = ?))"
 
-		for rows.Next() {
-			var id int
-			var category, posted, url, title, image, p1 string
-			var textJSON []byte
-			var text []string
+// Items which were not processed yet have no deliveries
+const unsentCondition = "duplicate_of IS NULL AND (NOT EXISTS (SELECT 1 FROM deliveries WHERE item_id = news_items.id) OR EXISTS (SELECT 1 FROM deliveries WHERE item_id = news_items.id AND status = 'pending'))"
 
-			if err := rows.Scan(&id, &category, &posted, &url, &title, &image, &textJSON, &p1); err != nil {
-				return err
-			}
+const interruptedError = "interrupted while sending, the message may have been posted"
 
-			if err := json.Unmarshal(textJSON, &text); err != nil {
-				return err
-			}
+// Returns the stems of the title and the text to index, separated by spaces
+func searchTerms(title string, text []string) (string, string) {
+	return strings.Join(nlp.SearchTerms(title), " "), strings.Join(nlp.SearchTerms(strings.Join(text, " ")), " ")
+}
 
-			item := scraping.NewsItem{
-				Id:       id,
-				Category: category,
-				Posted:   posted,
-				Url:      url,
-				Title:    title,
-				Image:    image,
-				Text:     text,
-				P1:       p1,
-			}
+// Returns the FTS5 query matching all the stems of the query, empty if there are none. The stems are quoted, so the query syntax can't break it
+func matchQuery(query string) string {
+	var terms []string
+	for _, term := range nlp.SearchTerms(query) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
 
-			unsentItems = append(unsentItems, item)
-		}
+// Returns up to limit items matching all the stems of the query, ranked by the number of matching stems. The title counts twice.
+// Used where there is no search index
+func rankItems(items []scraping.NewsItem, query string, limit int) []scraping.NewsItem {
+	terms := nlp.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
 
-		if len(unsentItems) == 0 {
-			log.Println("No unsent items found...")
-			return nil
-		}
+	type match struct {
+		item scraping.NewsItem
+		rank int
+	}
 
-		sort.Slice(unsentItems, func(i, j int) bool {
-			timeI, _ := time.Parse("02-01-2006 15:04:05", unsentItems[i].Posted)
-			timeJ, _ := time.Parse("02-01-2006 15:04:05", unsentItems[j].Posted)
-			return timeI.Before(timeJ)
-		})
-
-		for _, item := range unsentItems {
-			err := external.SendToExternalService(item)
-			time.Sleep(time.Duration(reqSleepMs) * time.Millisecond)
-			if err != nil {
-				log.Printf("Error sending item with ID %d to external service: %v", item.Id, err)
-				continue
-			}
+	var matches []match
+	for _, item := range items {
+		titleTerms, textTerms := searchTerms(item.Title, item.Text)
+		counts := make(map[string]int)
+		for _, term := range strings.Fields(titleTerms) {
+			counts[term] += 2
+		}
+		for _, term := range strings.Fields(textTerms) {
+			counts[term]++
+		}
 
-			updateQuery := "UPDATE news_items SET item_was_sent = true WHERE id = ?"
-			_, err = tx.Exec(updateQuery, item.Id)
-			if err != nil {
-				log.Printf("Error updating item_was_sent flag for item with ID %d: %v", item.Id, err)
-				tx.Rollback()
-				continue
+		rank := 0
+		for _, term := range terms {
+			if counts[term] == 0 {
+				rank = 0
+				break
 			}
+			rank += counts[term]
 		}
-
-		if err := tx.Commit(); err != nil {
-			return err
+		if rank > 0 {
+			matches = append(matches, match{item: item, rank: rank})
 		}
-	} else {
-		log.Printf("DRY-RUN: Finished processUnsentItems")
 	}
 
-	return nil
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].rank > matches[j].rank
+	})
+
+	var ranked []scraping.NewsItem
+	for i := 0; i < len(matches) && i < limit; i++ {
+		ranked = append(ranked, matches[i].item)
+	}
+	return ranked
 }
 
-func CheckIfRecordWithUrlExists(dryRun bool, debug bool, db *sql.DB, url string) (bool, error) {
-	if !dryRun {
-		query := "SELECT COUNT(*) FROM news_items WHERE url = ?"
-		var count int
-		err := db.QueryRow(query, url).Scan(&count)
-		if debug {
-			log.Printf("DEBUG: looked in db for item with %s url found %d\n", url, count)
-		}
-		if err != nil {
-			return false, err
-		}
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-		if count > 0 {
-			return true, nil
-		} else {
-			return false, nil
-		}
-	} else {
-		return true, nil
+func decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
 }
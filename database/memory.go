@@ -0,0 +1,406 @@
+package database
+
+import (
+	"goodnews/scraping"
+	"goodnews/taxonomy"
+	"goodnews/urlnorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in memory and loses it on exit. It is used for the dry runs and tests
+type MemoryStore struct {
+	mu           sync.Mutex
+	items        []scraping.NewsItem
+	deliveries   map[int]map[string]Delivery
+	claims       map[int]map[string]time.Time
+	snapshots    []scraping.Snapshot
+	imageChecks  map[int]ImageCheck
+	aliases      map[string]int
+	rejected     map[string]scraping.RejectedItem
+	translations map[int]map[string]Translation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		deliveries:   make(map[int]map[string]Delivery),
+		claims:       make(map[int]map[string]time.Time),
+		imageChecks:  make(map[int]ImageCheck),
+		aliases:      make(map[string]int),
+		rejected:     make(map[string]scraping.RejectedItem),
+		translations: make(map[int]map[string]Translation),
+	}
+}
+
+// Items returns all the stored items, for the tests
+func (m *MemoryStore) Items() []scraping.NewsItem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]scraping.NewsItem(nil), m.items...)
+}
+
+func (m *MemoryStore) item(id int) *scraping.NewsItem {
+	if id < 1 || id > len(m.items) {
+		return nil
+	}
+	return &m.items[id-1]
+}
+
+func (m *MemoryStore) ItemSent(url string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id, ok := m.aliases[urlnorm.Canonicalize(url)]; ok && m.wasSent(id) {
+		return true, nil
+	}
+	for _, item := range m.items {
+		if item.Url == url && m.wasSent(item.Id) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *MemoryStore) StoredUrl(item scraping.NewsItem) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.storedUrl(item.Url, itemAliases(item)), nil
+}
+
+// Returns the url of the oldest item stored with the url or under any of the aliases
+func (m *MemoryStore) storedUrl(url string, aliases []string) string {
+	for _, item := range m.items {
+		if item.Url == url {
+			return item.Url
+		}
+		for _, alias := range aliases {
+			if m.aliases[alias] == item.Id {
+				return item.Url
+			}
+		}
+	}
+	return ""
+}
+
+func (m *MemoryStore) UpsertItem(item scraping.NewsItem) (UpsertResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The site could serve the article under another url since it was stored, the stored one is kept
+	aliases := itemAliases(item)
+	if stored := m.storedUrl(item.Url, aliases); stored != "" {
+		item.Url = stored
+	}
+
+	result := UpsertResult{Status: Inserted}
+	for i := range m.items {
+		if m.items[i].Url != item.Url {
+			continue
+		}
+		if m.wasSent(m.items[i].Id) {
+			return UpsertResult{Status: Skipped}, nil
+		}
+		item.Id, item.DuplicateOf = m.items[i].Id, m.items[i].DuplicateOf
+		m.items[i] = item
+		result.Status = Updated
+		break
+	}
+
+	if result.Status == Inserted {
+		item.Id = len(m.items) + 1
+		m.items = append(m.items, item)
+	}
+	result.Id = item.Id
+
+	for _, alias := range aliases {
+		if _, ok := m.aliases[alias]; !ok {
+			m.aliases[alias] = item.Id
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MemoryStore) InsertRejectedItem(rejected scraping.RejectedItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rejected[rejected.Item.Url] = rejected
+	return nil
+}
+
+func (m *MemoryStore) DeleteRejectedItem(url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.rejected, url)
+	return nil
+}
+
+// Rejected returns the rejection of the url, for the tests
+func (m *MemoryStore) Rejected(url string) (scraping.RejectedItem, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rejected, ok := m.rejected[url]
+	return rejected, ok
+}
+
+func (m *MemoryStore) RecentFingerprints(since time.Time) ([]ItemFingerprint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var fingerprints []ItemFingerprint
+	for _, item := range m.items {
+		if item.Fingerprint != 0 && item.DuplicateOf == 0 && postedAfter(item.Posted, since) {
+			fingerprints = append(fingerprints, ItemFingerprint{Id: item.Id, Fingerprint: item.Fingerprint})
+		}
+	}
+
+	return fingerprints, nil
+}
+
+func (m *MemoryStore) UnsentItems(since time.Time) ([]scraping.NewsItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []scraping.NewsItem
+	for _, item := range m.items {
+		if item.DuplicateOf == 0 && m.isUnsent(item.Id) && postedAfter(item.Posted, since) {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+func (m *MemoryStore) LabelledItems() ([]scraping.NewsItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []scraping.NewsItem
+	for _, item := range m.items {
+		if item.CategorySource == taxonomy.ByRule {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+func (m *MemoryStore) UpdateFilterRule(id int, filterRule string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if item := m.item(id); item != nil {
+		item.FilterRule = filterRule
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateImageCheck(id int, check ImageCheck) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if item := m.item(id); item != nil {
+		item.ImageStatus = check.Status
+		m.imageChecks[id] = check
+	}
+	return nil
+}
+
+// ImageCheck returns the stored result of the image check of the item, for the tests
+func (m *MemoryStore) ImageCheck(id int) (ImageCheck, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	check, ok := m.imageChecks[id]
+	return check, ok
+}
+
+func (m *MemoryStore) wasSent(id int) bool {
+	for _, delivery := range m.deliveries[id] {
+		if delivery.Status == DeliverySent {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) isUnsent(id int) bool {
+	if len(m.deliveries[id]) == 0 {
+		return true
+	}
+	for _, delivery := range m.deliveries[id] {
+		if delivery.Status == DeliveryPending {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) Deliveries(id int) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range m.deliveries[id] {
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Destination < deliveries[j].Destination
+	})
+
+	return deliveries, nil
+}
+
+func (m *MemoryStore) SaveDelivery(delivery Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.deliveries[delivery.ItemId] == nil {
+		m.deliveries[delivery.ItemId] = make(map[string]Delivery)
+	}
+	m.deliveries[delivery.ItemId][delivery.Destination] = delivery
+	delete(m.claims[delivery.ItemId], delivery.Destination)
+	return nil
+}
+
+func (m *MemoryStore) AddDelivery(delivery Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.deliveries[delivery.ItemId][delivery.Destination]; ok {
+		return nil
+	}
+	if m.deliveries[delivery.ItemId] == nil {
+		m.deliveries[delivery.ItemId] = make(map[string]Delivery)
+	}
+	m.deliveries[delivery.ItemId][delivery.Destination] = delivery
+	return nil
+}
+
+func (m *MemoryStore) ClaimDelivery(id int, destination string, lease time.Duration) (Delivery, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery, ok := m.deliveries[id][destination]
+	if !ok || delivery.Status != DeliveryPending {
+		return Delivery{}, false, nil
+	}
+
+	delivery.Status = DeliverySending
+	m.deliveries[id][destination] = delivery
+	if m.claims[id] == nil {
+		m.claims[id] = make(map[string]time.Time)
+	}
+	m.claims[id][destination] = time.Now().Add(lease)
+
+	return delivery, true, nil
+}
+
+func (m *MemoryStore) ExpireClaims() ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []Delivery
+	for id, claims := range m.claims {
+		for destination, until := range claims {
+			delivery := m.deliveries[id][destination]
+			if delivery.Status != DeliverySending || until.After(time.Now()) {
+				continue
+			}
+			delivery.Status, delivery.LastError = DeliveryFailed, interruptedError
+			m.deliveries[id][destination] = delivery
+			delete(claims, destination)
+			expired = append(expired, delivery)
+		}
+	}
+
+	return expired, nil
+}
+
+func (m *MemoryStore) Translation(id int, lang string) (Translation, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	translation, ok := m.translations[id][lang]
+	return translation, ok, nil
+}
+
+func (m *MemoryStore) SaveTranslation(id int, translation Translation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.translations[id] == nil {
+		m.translations[id] = make(map[string]Translation)
+	}
+	m.translations[id][translation.Language] = translation
+	return nil
+}
+
+// Snapshots are kept uncompressed
+func (m *MemoryStore) SaveSnapshot(snapshot scraping.Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot.Id = 1
+	if n := len(m.snapshots); n > 0 {
+		snapshot.Id = m.snapshots[n-1].Id + 1
+	}
+	m.snapshots = append(m.snapshots, snapshot)
+	return nil
+}
+
+func (m *MemoryStore) Snapshots(source string, since time.Time) ([]scraping.Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var snapshots []scraping.Snapshot
+	for _, snapshot := range m.snapshots {
+		if snapshot.Source == source && !snapshot.FetchedAt.Before(since) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return snapshots, nil
+}
+
+func (m *MemoryStore) PruneSnapshots(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []scraping.Snapshot
+	for _, snapshot := range m.snapshots {
+		if !snapshot.FetchedAt.Before(before) {
+			kept = append(kept, snapshot)
+		}
+	}
+
+	deleted := len(m.snapshots) - len(kept)
+	m.snapshots = kept
+	return deleted, nil
+}
+
+func (m *MemoryStore) Search(query string, limit int) ([]scraping.NewsItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := make([]scraping.NewsItem, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	// Items with the same rank are returned in the order they were stored
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Id < items[j].Id
+	})
+	return rankItems(items, query, limit), nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,432 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"goodnews/scraping"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Migrations of each driver are in migrations/<driver>, with the same versions and names
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+const (
+	driverSQLite   = "sqlite3"
+	driverPostgres = "postgres"
+)
+
+func migrationsDir(driver string) string {
+	if driver == driverSQLite {
+		return "migrations/sqlite"
+	}
+	return "migrations/" + driver
+}
+
+// Migrator is implemented by the stores with a schema
+type Migrator interface {
+	MigrateUp() error
+	MigrateDown() error
+	MigrationStatus() ([]MigrationState, error)
+}
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up, Down string
+	// Optional feature of the driver the migration needs, set by a "-- requires: <feature>" line of the up script. Without it, the migration is left pending
+	Requires string
+}
+
+type MigrationState struct {
+	Migration
+	// Empty if the migration is pending
+	AppliedAt string
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var requiresLine = regexp.MustCompile(`(?m)^--\s*requires:\s*(\w+)\s*$`)
+
+// Destructive steps lose data, so the db is backed up before them
+var destructiveStatement = regexp.MustCompile(`(?i)\b(DROP|DELETE)\b`)
+
+func loadMigrations(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, migrationsDir(driver))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %s", entry.Name())
+		}
+
+		data, err := migrationFiles.ReadFile(migrationsDir(driver) + "/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		if byVersion[version] == nil {
+			byVersion[version] = &Migration{Version: version, Name: match[2]}
+		}
+		if match[3] == "up" {
+			byVersion[version].Up = string(data)
+			if requires := requiresLine.FindStringSubmatch(string(data)); requires != nil {
+				byVersion[version].Requires = requires[1]
+			}
+		} else {
+			byVersion[version].Down = string(data)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func appliedMigrations(db *sql.DB) (map[int]string, error) {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT, applied_at TEXT)")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func migrationStatus(db *sql.DB, driver string) ([]MigrationState, error) {
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	for _, migration := range migrations {
+		states = append(states, MigrationState{Migration: migration, AppliedAt: applied[migration.Version]})
+	}
+
+	return states, nil
+}
+
+// Key of the Postgres advisory lock held while migrating
+const migrationLockKey = 4206202
+
+// Instances sharing a Postgres db migrate one at a time, the others wait and then find the migrations applied. The session lock
+// is held on its own connection, so it is released even if the migration fails
+func lockMigrations(db *sql.DB, driver string) (func(), error) {
+	if driver != driverPostgres {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("locking the migrations: %v", err)
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			log.Printf("Error unlocking the migrations: %v", err)
+		}
+		conn.Close()
+	}, nil
+}
+
+// migrateUp applies all the pending migrations in order, each in its own transaction
+func migrateUp(db *sql.DB, driver string) error {
+	unlock, err := lockMigrations(db, driver)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	states, err := migrationStatus(db, driver)
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		if state.AppliedAt != "" {
+			continue
+		}
+
+		if state.Requires != "" {
+			supported, err := driverSupports(db, driver, state.Requires)
+			if err != nil {
+				return err
+			}
+			if !supported {
+				log.Printf("Migration %04d_%s is left pending, the %s driver is built without %s", state.Version, state.Name, driver, state.Requires)
+				continue
+			}
+		}
+
+		if destructiveStatement.MatchString(state.Up) {
+			if err := backup(db, driver, state.Migration, "up"); err != nil {
+				return err
+			}
+		}
+
+		err = runMigration(db, state.Up, rebind(driver, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), state.Version, state.Name, scraping.FormatPosted(time.Now()))
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %v", state.Version, state.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", state.Version, state.Name)
+	}
+
+	return nil
+}
+
+// migrateDown reverts the latest applied migration. The db is always backed up before
+func migrateDown(db *sql.DB, driver string) error {
+	unlock, err := lockMigrations(db, driver)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	states, err := migrationStatus(db, driver)
+	if err != nil {
+		return err
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		state := states[i]
+		if state.AppliedAt == "" {
+			continue
+		}
+
+		if state.Down == "" {
+			return fmt.Errorf("migration %04d_%s can't be reverted", state.Version, state.Name)
+		}
+
+		if state.Requires != "" {
+			supported, err := driverSupports(db, driver, state.Requires)
+			if err != nil {
+				return err
+			}
+			if !supported {
+				return fmt.Errorf("migration %04d_%s can't be reverted, the %s driver is built without %s", state.Version, state.Name, driver, state.Requires)
+			}
+		}
+
+		if err := backup(db, driver, state.Migration, "down"); err != nil {
+			return err
+		}
+
+		err = runMigration(db, state.Down, rebind(driver, "DELETE FROM schema_migrations WHERE version = ?"), state.Version)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %v", state.Version, state.Name, err)
+		}
+		log.Printf("Reverted migration %04d_%s", state.Version, state.Name)
+		return nil
+	}
+
+	log.Println("No applied migrations to revert")
+	return nil
+}
+
+// Reports whether the driver has the feature a migration requires
+func driverSupports(db *sql.DB, driver, feature string) (bool, error) {
+	switch {
+	case driver == driverSQLite && feature == "fts5":
+		// Only with -tags sqlite_fts5
+		var supported bool
+		err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&supported)
+		return supported, err
+	default:
+		return false, fmt.Errorf("unknown feature %q of the %s driver", feature, driver)
+	}
+}
+
+func runMigration(db *sql.DB, script, recordQuery string, recordArgs ...interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if strings.TrimSpace(script) != "" {
+		if _, err := tx.Exec(script); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(recordQuery, recordArgs...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replaces the ? placeholders with $1, $2... for Postgres
+func rebind(driver, query string) string {
+	if driver != driverPostgres {
+		return query
+	}
+
+	var result strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			result.WriteString(fmt.Sprintf("$%d", n))
+		} else {
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+func backup(db *sql.DB, driver string, migration Migration, direction string) error {
+	if driver == driverPostgres {
+		return backupPostgres(db, migration, direction)
+	}
+	return backupSQLite(db, migration, direction)
+}
+
+// Copies the db next to the original file, e.g. data/news_items.db.0002-down-20240101-150405.bak
+func backupSQLite(db *sql.DB, migration Migration, direction string) error {
+	var seq int
+	var name, file string
+	if err := db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		return err
+	}
+	if file == "" {
+		// In-memory db
+		return nil
+	}
+
+	backupFile := fmt.Sprintf("%s.%04d-%s-%s.bak", file, migration.Version, direction, time.Now().Format("20060102-150405"))
+	// Migrations reverted and applied again within a second get a numbered backup
+	for n := 2; fileExists(backupFile); n++ {
+		backupFile = fmt.Sprintf("%s.%04d-%s-%s-%d.bak", file, migration.Version, direction, time.Now().Format("20060102-150405"), n)
+	}
+	if _, err := db.Exec("VACUUM INTO ?", backupFile); err != nil {
+		return fmt.Errorf("backup before migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+	log.Printf("Backed up the db to %s before migration %04d_%s %s", backupFile, migration.Version, migration.Name, direction)
+
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Copies the tables to a new schema, e.g. backup_0002_down_20240101_150405, as pg_dump may not be available where the bot runs
+func backupPostgres(db *sql.DB, migration Migration, direction string) error {
+	schema := fmt.Sprintf("backup_%04d_%s_%s", migration.Version, direction, time.Now().Format("20060102_150405"))
+	for n := 2; ; n++ {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)", schema).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			break
+		}
+		schema = fmt.Sprintf("backup_%04d_%s_%s_%d", migration.Version, direction, time.Now().Format("20060102_150405"), n)
+	}
+
+	var source string
+	if err := db.QueryRow("SELECT current_schema()").Scan(&source); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT tablename FROM pg_tables WHERE schemaname = $1", source)
+	if err != nil {
+		return err
+	}
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE SCHEMA " + schema); err != nil {
+		return fmt.Errorf("backup before migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+	// The comment tells the backups of the schemas sharing the db apart
+	if _, err := tx.Exec(fmt.Sprintf("COMMENT ON SCHEMA %s IS %s", schema, pq.QuoteLiteral(backupComment(source, migration, direction)))); err != nil {
+		return fmt.Errorf("backup before migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s.%s AS TABLE %s.%s", schema, table, source, table)); err != nil {
+			return fmt.Errorf("backup of %s before migration %04d_%s: %v", table, migration.Version, migration.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Backed up the db to the schema %s before migration %04d_%s %s", schema, migration.Version, migration.Name, direction)
+
+	return nil
+}
+
+func backupComment(schema string, migration Migration, direction string) string {
+	return fmt.Sprintf("Backup of %s before migration %04d_%s %s", schema, migration.Version, migration.Name, direction)
+}
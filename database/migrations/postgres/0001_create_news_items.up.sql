@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS news_items (
+    id SERIAL PRIMARY KEY,
+    url TEXT,
+    category TEXT,
+    posted TIMESTAMPTZ,
+    title TEXT,
+    image TEXT,
+    text JSONB,
+    p1 TEXT,
+    item_was_sent BOOLEAN NOT NULL DEFAULT false
+);
@@ -0,0 +1,13 @@
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS normalized_category TEXT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS author TEXT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS images JSONB;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS image_status TEXT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS source TEXT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS fingerprint BIGINT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS duplicate_of INTEGER;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS sentiment DOUBLE PRECISION;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS filter_rule TEXT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS category_source TEXT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS locations JSONB;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS language TEXT;
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS charset TEXT;
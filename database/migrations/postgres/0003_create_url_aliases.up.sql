@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS url_aliases (
+    url TEXT PRIMARY KEY,
+    item_id INTEGER NOT NULL
+);
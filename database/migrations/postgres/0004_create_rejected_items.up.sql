@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rejected_items (
+    url TEXT PRIMARY KEY,
+    source TEXT,
+    title TEXT,
+    reasons JSONB,
+    rejected_at TIMESTAMPTZ
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS translations (
+    item_id INTEGER,
+    language TEXT,
+    category TEXT,
+    title TEXT,
+    text JSONB,
+    PRIMARY KEY (item_id, language)
+);
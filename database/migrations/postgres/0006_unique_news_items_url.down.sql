@@ -0,0 +1,2 @@
+DROP INDEX news_items_url;
+ALTER TABLE news_items DROP COLUMN updates;
@@ -0,0 +1,3 @@
+ALTER TABLE news_items ADD COLUMN IF NOT EXISTS item_was_sent BOOLEAN NOT NULL DEFAULT false;
+UPDATE news_items SET item_was_sent = EXISTS (SELECT 1 FROM deliveries WHERE item_id = news_items.id AND status = 'sent');
+DROP TABLE deliveries;
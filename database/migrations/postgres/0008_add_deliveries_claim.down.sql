@@ -0,0 +1,2 @@
+UPDATE deliveries SET status = 'failed' WHERE status = 'sending';
+ALTER TABLE deliveries DROP COLUMN claimed_until;
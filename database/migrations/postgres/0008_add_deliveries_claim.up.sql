@@ -0,0 +1,2 @@
+-- Time when the claim of the sending delivery expires
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
@@ -0,0 +1 @@
+DROP INDEX news_items_search;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS news_items_search ON news_items USING GIN (to_tsvector('russian', COALESCE(title, '') || ' ' || COALESCE(text::text, '')));
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS snapshots (
+    id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    final_url TEXT,
+    source TEXT,
+    -- Content-Type header of the response, the charset is detected again from it and the page
+    content_type TEXT,
+    fetched_at TIMESTAMPTZ NOT NULL,
+    -- gzip of the page as it was served
+    html BYTEA NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_source_fetched_at ON snapshots (source, fetched_at);
@@ -0,0 +1,2 @@
+ALTER TABLE news_items DROP COLUMN checked_image;
+ALTER TABLE news_items DROP COLUMN checked_images;
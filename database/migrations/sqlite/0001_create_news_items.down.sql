@@ -0,0 +1 @@
+DROP TABLE news_items;
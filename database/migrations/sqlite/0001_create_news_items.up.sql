@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS news_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT,
+    category TEXT,
+    posted TEXT,
+    title TEXT,
+    image TEXT,
+    text TEXT,
+    p1 TEXT,
+    item_was_sent BOOLEAN
+);
@@ -0,0 +1,13 @@
+ALTER TABLE news_items DROP COLUMN normalized_category;
+ALTER TABLE news_items DROP COLUMN author;
+ALTER TABLE news_items DROP COLUMN images;
+ALTER TABLE news_items DROP COLUMN image_status;
+ALTER TABLE news_items DROP COLUMN source;
+ALTER TABLE news_items DROP COLUMN fingerprint;
+ALTER TABLE news_items DROP COLUMN duplicate_of;
+ALTER TABLE news_items DROP COLUMN sentiment;
+ALTER TABLE news_items DROP COLUMN filter_rule;
+ALTER TABLE news_items DROP COLUMN category_source;
+ALTER TABLE news_items DROP COLUMN locations;
+ALTER TABLE news_items DROP COLUMN language;
+ALTER TABLE news_items DROP COLUMN charset;
@@ -0,0 +1,14 @@
+-- Columns added in 2.2.0. The news_items table of 2.1.0 is the one of 0001_create_news_items
+ALTER TABLE news_items ADD COLUMN normalized_category TEXT;
+ALTER TABLE news_items ADD COLUMN author TEXT;
+ALTER TABLE news_items ADD COLUMN images TEXT;
+ALTER TABLE news_items ADD COLUMN image_status TEXT;
+ALTER TABLE news_items ADD COLUMN source TEXT;
+ALTER TABLE news_items ADD COLUMN fingerprint INTEGER;
+ALTER TABLE news_items ADD COLUMN duplicate_of INTEGER;
+ALTER TABLE news_items ADD COLUMN sentiment REAL;
+ALTER TABLE news_items ADD COLUMN filter_rule TEXT;
+ALTER TABLE news_items ADD COLUMN category_source TEXT;
+ALTER TABLE news_items ADD COLUMN locations TEXT;
+ALTER TABLE news_items ADD COLUMN language TEXT;
+ALTER TABLE news_items ADD COLUMN charset TEXT;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rejected_items (
+    url TEXT PRIMARY KEY,
+    source TEXT,
+    title TEXT,
+    reasons TEXT,
+    rejected_at TEXT
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS translations (
+    item_id INTEGER,
+    language TEXT,
+    category TEXT,
+    title TEXT,
+    text TEXT,
+    PRIMARY KEY (item_id, language)
+);
@@ -0,0 +1,12 @@
+-- Overlapping runs could store the same url twice, only the first row is kept and gets the aliases of the others
+UPDATE url_aliases SET item_id = (
+    SELECT MIN(kept.id) FROM news_items dup JOIN news_items kept ON kept.url = dup.url WHERE dup.id = url_aliases.item_id
+) WHERE item_id IN (SELECT id FROM news_items WHERE url IS NOT NULL AND id NOT IN (SELECT MIN(id) FROM news_items GROUP BY url));
+-- The kept row is marked as sent when any of the duplicates was, so the item is not posted again
+UPDATE news_items SET item_was_sent = 1 WHERE id IN (
+    SELECT MIN(id) FROM news_items WHERE url IS NOT NULL GROUP BY url HAVING COUNT(*) > 1 AND MAX(COALESCE(item_was_sent, 0)) = 1
+);
+DELETE FROM news_items WHERE url IS NOT NULL AND id NOT IN (SELECT MIN(id) FROM news_items GROUP BY url);
+CREATE UNIQUE INDEX IF NOT EXISTS news_items_url ON news_items (url);
+-- Number of times the unsent item was updated by a later scrape
+ALTER TABLE news_items ADD COLUMN updates INTEGER NOT NULL DEFAULT 0;
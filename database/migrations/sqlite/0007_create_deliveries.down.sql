@@ -0,0 +1,3 @@
+ALTER TABLE news_items ADD COLUMN item_was_sent BOOLEAN;
+UPDATE news_items SET item_was_sent = id IN (SELECT item_id FROM deliveries WHERE status = 'sent');
+DROP TABLE deliveries;
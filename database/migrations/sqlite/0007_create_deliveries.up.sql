@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS deliveries (
+    item_id INTEGER NOT NULL,
+    destination TEXT NOT NULL,
+    -- pending, sending while claimed, sent or failed after the last attempt
+    status TEXT NOT NULL CHECK (status IN ('pending', 'sending', 'sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    -- ID of the message returned by Telegram
+    message_id TEXT,
+    sent_at TEXT,
+    PRIMARY KEY (item_id, destination)
+);
+-- The flag didn't say which chats got the item, so the sent items are recorded for the default one
+INSERT OR IGNORE INTO deliveries (item_id, destination, status, attempts) SELECT id, 'default', 'sent', 1 FROM news_items WHERE item_was_sent;
+ALTER TABLE news_items DROP COLUMN item_was_sent;
@@ -0,0 +1,2 @@
+-- Unix time when the claim of the sending delivery expires
+ALTER TABLE deliveries ADD COLUMN claimed_until INTEGER;
@@ -0,0 +1,4 @@
+-- requires: fts5
+-- The stems of the titles and texts, as SQLite has no Russian stemmer. The rowid is the ID of the item.
+-- Left pending by the builds without -tags sqlite_fts5, the search ranks the items without an index then
+CREATE VIRTUAL TABLE news_items_fts USING fts5(title_terms, text_terms);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    final_url TEXT,
+    source TEXT,
+    -- Content-Type header of the response, the charset is detected again from it and the page
+    content_type TEXT,
+    -- Unix time
+    fetched_at INTEGER NOT NULL,
+    -- gzip of the page as it was served
+    html BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_source_fetched_at ON snapshots (source, fetched_at);
@@ -0,0 +1,3 @@
+-- Result of the image check before sending, the scraped image and images are kept as is so they can be checked again
+ALTER TABLE news_items ADD COLUMN checked_image TEXT;
+ALTER TABLE news_items ADD COLUMN checked_images TEXT;
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"goodnews/scraping"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	sqlite, err := OpenSQLite(filepath.Join(t.TempDir(), "news_items.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	items := []scraping.NewsItem{
+		{Url: "https://example.com/news/1", Title: "Волонтёры спасли щенка", Text: []string{"Щенка нашли в лесу."}},
+		{Url: "https://example.com/news/2", Title: "Отец и сын построили скворечник", Text: []string{"Скворечник повесили во дворе."}},
+		{Url: "https://example.com/news/3", Title: "В парке открыли каток", Text: []string{"Каток работает до марта, щенков на лёд не пускают."}},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "other form", query: "щенки", want: []string{"https://example.com/news/1", "https://example.com/news/3"}},
+		{name: "fleeting vowel", query: "щенок", want: []string{"https://example.com/news/1", "https://example.com/news/3"}},
+		{name: "fleeting vowel in the text", query: "отца", want: []string{"https://example.com/news/2"}},
+		{name: "all the words", query: "каток щенок", want: []string{"https://example.com/news/3"}},
+		{name: "stop words only", query: "и в на"},
+	}
+
+	for _, store := range []Store{NewMemoryStore(), sqlite} {
+		for _, item := range items {
+			item.Posted = scraping.FormatPosted(time.Now())
+			if _, err := store.UpsertItem(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				found, err := store.Search(tt.query, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got []string
+				for _, item := range found {
+					got = append(got, item.Url)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("%T: got %v, want %v", store, got, tt.want)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Fatalf("%T: got %v, want %v", store, got, tt.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSearchIndexMigration(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "news_items.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	supported, err := driverSupports(s.db, driverSQLite, "fts5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.fts != supported {
+		t.Errorf("got the search index used: %t, FTS5 supported: %t", s.fts, supported)
+	}
+
+	states, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if state.Requires == "" {
+			if state.AppliedAt == "" {
+				t.Errorf("migration %04d_%s is not applied", state.Version, state.Name)
+			}
+			continue
+		}
+		// Left pending without the feature, and applied by a build with it
+		if applied := state.AppliedAt != ""; applied != supported {
+			t.Errorf("got migration %04d_%s applied: %t, %s supported: %t", state.Version, state.Name, applied, state.Requires, supported)
+		}
+	}
+}
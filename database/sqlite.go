@@ -0,0 +1,565 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"goodnews/scraping"
+	"goodnews/taxonomy"
+	"goodnews/urlnorm"
+	"log"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type SQLiteStore struct {
+	db *sql.DB
+	// The search index is only kept if the driver is built with FTS5 (-tags sqlite_fts5) and its migration is applied, otherwise the items are ranked in Go
+	fts bool
+}
+
+// OpenSQLite opens the db and, with autoMigrate, applies the pending migrations
+func OpenSQLite(filePath string, autoMigrate bool) (*SQLiteStore, error) {
+	db, err := sql.Open(driverSQLite, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStore{db: db}
+
+	if autoMigrate {
+		if err := s.MigrateUp(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migrating %s: %w", filePath, err)
+		}
+
+		if err := s.backfillUrlAliases(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("backfilling url aliases: %w", err)
+		}
+		log.Println("Database setup completed")
+	}
+
+	s.fts, err = s.searchIndexReady()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("checking the search index: %w", err)
+	}
+
+	if autoMigrate && s.fts {
+		if err := s.backfillSearchIndex(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("backfilling the search index: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *SQLiteStore) MigrateUp() error {
+	return migrateUp(s.db, driverSQLite)
+}
+
+func (s *SQLiteStore) MigrateDown() error {
+	return migrateDown(s.db, driverSQLite)
+}
+
+func (s *SQLiteStore) MigrationStatus() ([]MigrationState, error) {
+	return migrationStatus(s.db, driverSQLite)
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Raw and canonical urls of the items stored before the aliases were introduced, so they are still matched
+func (s *SQLiteStore) backfillUrlAliases() error {
+	rows, err := s.db.Query("SELECT id, url FROM news_items WHERE id NOT IN (SELECT item_id FROM url_aliases)")
+	if err != nil {
+		return err
+	}
+
+	aliases := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			rows.Close()
+			return err
+		}
+		aliases[url] = id
+		aliases[urlnorm.Canonicalize(url)] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for alias, id := range aliases {
+		if _, err := s.db.Exec("INSERT OR IGNORE INTO url_aliases (url, item_id) VALUES (?, ?)", alias, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The search uses the FTS5 table of the stems if the driver is built with FTS5 and the migration creating it is applied
+func (s *SQLiteStore) searchIndexReady() (bool, error) {
+	supported, err := driverSupports(s.db, driverSQLite, "fts5")
+	if err != nil {
+		return false, err
+	}
+
+	var tables int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'news_items_fts'").Scan(&tables); err != nil {
+		return false, err
+	}
+
+	switch {
+	case supported && tables > 0:
+		return true, nil
+	case supported:
+		log.Println("The search index is not created yet, the search ranks the items without it until the migrations are applied")
+	case tables > 0:
+		log.Println("SQLite is built without FTS5, the search ranks the items without the index and the stored items are not indexed. Build with -tags sqlite_fts5 to use it")
+	default:
+		log.Println("SQLite is built without FTS5, the search ranks the items without an index. Build with -tags sqlite_fts5 to index them")
+	}
+	return false, nil
+}
+
+// Items stored before the search index was introduced or by a build without FTS5
+func (s *SQLiteStore) backfillSearchIndex() error {
+	rows, err := s.db.Query("SELECT id, title, text FROM news_items WHERE id NOT IN (SELECT rowid FROM news_items_fts)")
+	if err != nil {
+		return err
+	}
+
+	type indexedItem struct {
+		id          int
+		title, text string
+	}
+
+	var items []indexedItem
+	for rows.Next() {
+		var id int
+		var title sql.NullString
+		var textJSON []byte
+		var text []string
+		if err := rows.Scan(&id, &title, &textJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		// Malformed text is indexed by the title only
+		json.Unmarshal(textJSON, &text)
+
+		titleTerms, textTerms := searchTerms(title.String, text)
+		items = append(items, indexedItem{id: id, title: titleTerms, text: textTerms})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if _, err := s.db.Exec("INSERT INTO news_items_fts (rowid, title_terms, text_terms) VALUES (?, ?, ?)", item.id, item.title, item.text); err != nil {
+			return err
+		}
+	}
+
+	if len(items) > 0 {
+		log.Printf("Added %d items to the search index", len(items))
+	}
+	return nil
+}
+
+func indexItem(tx *sql.Tx, id int, title string, text []string) error {
+	if _, err := tx.Exec("DELETE FROM news_items_fts WHERE rowid = ?", id); err != nil {
+		return err
+	}
+
+	titleTerms, textTerms := searchTerms(title, text)
+	_, err := tx.Exec("INSERT INTO news_items_fts (rowid, title_terms, text_terms) VALUES (?, ?, ?)", id, titleTerms, textTerms)
+	return err
+}
+
+func (s *SQLiteStore) ItemSent(url string) (bool, error) {
+	var sent bool
+	err := s.db.QueryRow(sentItemQuery, url, urlnorm.Canonicalize(url)).Scan(&sent)
+	return sent, err
+}
+
+func (s *SQLiteStore) StoredUrl(item scraping.NewsItem) (string, error) {
+	return storedUrl(s.db, driverSQLite, item.Url, itemAliases(item))
+}
+
+func (s *SQLiteStore) UpsertItem(item scraping.NewsItem) (UpsertResult, error) {
+	var result UpsertResult
+
+	textJSON, err := json.Marshal(item.Text)
+	if err != nil {
+		return result, err
+	}
+
+	imagesJSON, err := json.Marshal(item.Images)
+	if err != nil {
+		return result, err
+	}
+
+	locationsJSON, err := json.Marshal(item.Locations)
+	if err != nil {
+		return result, err
+	}
+
+	var duplicateOf sql.NullInt64
+	if item.DuplicateOf > 0 {
+		duplicateOf = sql.NullInt64{Int64: int64(item.DuplicateOf), Valid: true}
+	}
+
+	var p1 string
+	if len(item.Text) > 0 {
+		p1 = item.Text[0]
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// The site could serve the article under another url since it was stored, the stored one is kept
+	aliases := itemAliases(item)
+	stored, err := storedUrl(tx, driverSQLite, item.Url, aliases)
+	if err != nil {
+		return result, err
+	}
+	if stored != "" {
+		item.Url = stored
+	}
+
+	var updates int
+	err = tx.QueryRow(upsertQuery(driverSQLite), item.Url, item.Category, item.NormalizedCategory, item.Posted, item.Title, item.Image, textJSON, p1, item.Author, imagesJSON, item.Source, int64(item.Fingerprint), duplicateOf, item.Sentiment, item.FilterRule, item.CategorySource, locationsJSON, item.Language, item.Charset).Scan(&result.Id, &updates)
+	if err == sql.ErrNoRows {
+		result.Status = Skipped
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Status = Inserted
+	if updates > 0 {
+		result.Status = Updated
+	}
+
+	for _, alias := range aliases {
+		_, err = tx.Exec("INSERT INTO url_aliases (url, item_id) VALUES (?, ?) ON CONFLICT (url) DO NOTHING", alias, result.Id)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if s.fts {
+		if err := indexItem(tx, result.Id, item.Title, item.Text); err != nil {
+			return result, err
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+func (s *SQLiteStore) InsertRejectedItem(rejected scraping.RejectedItem) error {
+	reasonsJSON, err := json.Marshal(rejected.Rejections)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT OR REPLACE INTO rejected_items (url, source, title, reasons, rejected_at) VALUES (?, ?, ?, ?, ?)", rejected.Item.Url, rejected.Item.Source, rejected.Item.Title, reasonsJSON, scraping.FormatPosted(time.Now()))
+	return err
+}
+
+func (s *SQLiteStore) DeleteRejectedItem(url string) error {
+	_, err := s.db.Exec("DELETE FROM rejected_items WHERE url = ?", url)
+	return err
+}
+
+func (s *SQLiteStore) RecentFingerprints(since time.Time) ([]ItemFingerprint, error) {
+	rows, err := s.db.Query("SELECT id, posted, fingerprint FROM news_items WHERE fingerprint IS NOT NULL AND duplicate_of IS NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fingerprints []ItemFingerprint
+	for rows.Next() {
+		var id int
+		var posted string
+		var fingerprint int64
+
+		if err := rows.Scan(&id, &posted, &fingerprint); err != nil {
+			return nil, err
+		}
+
+		if postedAfter(posted, since) {
+			fingerprints = append(fingerprints, ItemFingerprint{Id: id, Fingerprint: uint64(fingerprint)})
+		}
+	}
+
+	return fingerprints, rows.Err()
+}
+
+const newsItemColumns = "id, category, COALESCE(normalized_category, ''), posted, url, title, image, text, p1, COALESCE(author, ''), COALESCE(images, 'null'), COALESCE(source, ''), COALESCE(sentiment, 0), COALESCE(locations, 'null'), COALESCE(language, '')"
+
+func scanNewsItems(rows *sql.Rows) ([]scraping.NewsItem, error) {
+	defer rows.Close()
+
+	var items []scraping.NewsItem
+
+	for rows.Next() {
+		var id int
+		var category, normalizedCategory, posted, url, title, image, p1, author, source, lang string
+		var textJSON, imagesJSON, locationsJSON []byte
+		var text, images, locations []string
+		var sentimentScore float64
+
+		if err := rows.Scan(&id, &category, &normalizedCategory, &posted, &url, &title, &image, &textJSON, &p1, &author, &imagesJSON, &source, &sentimentScore, &locationsJSON, &lang); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(locationsJSON, &locations); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(imagesJSON, &images); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(textJSON, &text); err != nil {
+			return nil, err
+		}
+
+		item := scraping.NewsItem{
+			Id:                 id,
+			Category:           category,
+			NormalizedCategory: normalizedCategory,
+			Posted:             posted,
+			Url:                url,
+			Title:              title,
+			Image:              image,
+			Text:               text,
+			P1:                 p1,
+			Author:             author,
+			Images:             images,
+			Source:             source,
+			Sentiment:          sentimentScore,
+			Locations:          locations,
+			Language:           lang,
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (s *SQLiteStore) UnsentItems(since time.Time) ([]scraping.NewsItem, error) {
+	rows, err := s.db.Query("SELECT " + newsItemColumns + " FROM news_items WHERE " + unsentCondition)
+	if err != nil {
+		return nil, err
+	}
+
+	// posted is stored as text, so the items are filtered by it after the query
+	items, err := scanNewsItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	return postedItems(items, since), nil
+}
+
+func (s *SQLiteStore) LabelledItems() ([]scraping.NewsItem, error) {
+	rows, err := s.db.Query("SELECT "+newsItemColumns+" FROM news_items WHERE category_source = ?", taxonomy.ByRule)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanNewsItems(rows)
+}
+
+func (s *SQLiteStore) UpdateFilterRule(id int, filterRule string) error {
+	_, err := s.db.Exec("UPDATE news_items SET filter_rule = ? WHERE id = ? AND filter_rule IS NOT ?", filterRule, id, filterRule)
+	return err
+}
+
+func (s *SQLiteStore) UpdateImageCheck(id int, check ImageCheck) error {
+	imagesJSON, err := json.Marshal(check.Images)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("UPDATE news_items SET checked_image = ?, checked_images = ?, image_status = ? WHERE id = ?", check.Image, imagesJSON, check.Status, id)
+	return err
+}
+
+func (s *SQLiteStore) Deliveries(id int) ([]Delivery, error) {
+	rows, err := s.db.Query("SELECT "+deliveryColumns+" FROM deliveries WHERE item_id = ? ORDER BY destination", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDeliveries(rows)
+}
+
+func scanDeliveries(rows *sql.Rows) ([]Delivery, error) {
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ItemId, &d.Destination, &d.Status, &d.Attempts, &d.LastError, &d.MessageId, &d.SentAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+const deliveryColumns = "item_id, destination, status, attempts, COALESCE(last_error, ''), COALESCE(message_id, ''), COALESCE(sent_at, '')"
+
+func (s *SQLiteStore) AddDelivery(d Delivery) error {
+	// OR IGNORE would skip the rows failing the status check as well
+	_, err := s.db.Exec("INSERT INTO deliveries (item_id, destination, status, attempts, last_error, message_id, sent_at) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (item_id, destination) DO NOTHING", d.ItemId, d.Destination, d.Status, d.Attempts, d.LastError, d.MessageId, d.SentAt)
+	return err
+}
+
+func (s *SQLiteStore) ClaimDelivery(id int, destination string, lease time.Duration) (Delivery, bool, error) {
+	rows, err := s.db.Query("UPDATE deliveries SET status = ?, claimed_until = ? WHERE item_id = ? AND destination = ? AND status = ? RETURNING "+deliveryColumns, DeliverySending, time.Now().Add(lease).Unix(), id, destination, DeliveryPending)
+	if err != nil {
+		return Delivery{}, false, err
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil || len(deliveries) == 0 {
+		return Delivery{}, false, err
+	}
+	return deliveries[0], true, nil
+}
+
+func (s *SQLiteStore) ExpireClaims() ([]Delivery, error) {
+	rows, err := s.db.Query("UPDATE deliveries SET status = ?, last_error = ?, claimed_until = NULL WHERE status = ? AND claimed_until < ? RETURNING "+deliveryColumns, DeliveryFailed, interruptedError, DeliverySending, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDeliveries(rows)
+}
+
+func (s *SQLiteStore) SaveDelivery(d Delivery) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO deliveries (item_id, destination, status, attempts, last_error, message_id, sent_at) VALUES (?, ?, ?, ?, ?, ?, ?)", d.ItemId, d.Destination, d.Status, d.Attempts, d.LastError, d.MessageId, d.SentAt)
+	return err
+}
+
+func (s *SQLiteStore) Translation(id int, lang string) (Translation, bool, error) {
+	translation := Translation{Language: lang}
+	var textJSON []byte
+
+	err := s.db.QueryRow("SELECT category, title, text FROM translations WHERE item_id = ? AND language = ?", id, lang).Scan(&translation.Category, &translation.Title, &textJSON)
+	if err == sql.ErrNoRows {
+		return translation, false, nil
+	}
+	if err != nil {
+		return translation, false, err
+	}
+
+	if err := json.Unmarshal(textJSON, &translation.Text); err != nil {
+		return translation, false, err
+	}
+
+	return translation, true, nil
+}
+
+func (s *SQLiteStore) SaveTranslation(id int, translation Translation) error {
+	textJSON, err := json.Marshal(translation.Text)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT OR REPLACE INTO translations (item_id, language, category, title, text) VALUES (?, ?, ?, ?, ?)", id, translation.Language, translation.Category, translation.Title, textJSON)
+	return err
+}
+
+func (s *SQLiteStore) SaveSnapshot(snapshot scraping.Snapshot) error {
+	html, err := compress(snapshot.Html)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT INTO snapshots (url, final_url, source, content_type, fetched_at, html) VALUES (?, ?, ?, ?, ?, ?)", snapshot.Url, snapshot.FinalUrl, snapshot.Source, snapshot.ContentType, snapshot.FetchedAt.Unix(), html)
+	return err
+}
+
+func (s *SQLiteStore) Snapshots(source string, since time.Time) ([]scraping.Snapshot, error) {
+	rows, err := s.db.Query("SELECT id, url, COALESCE(final_url, ''), COALESCE(source, ''), COALESCE(content_type, ''), fetched_at, html FROM snapshots WHERE source = ? AND fetched_at >= ? ORDER BY fetched_at, id", source, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []scraping.Snapshot
+	for rows.Next() {
+		var snapshot scraping.Snapshot
+		var fetchedAt int64
+		var html []byte
+		if err := rows.Scan(&snapshot.Id, &snapshot.Url, &snapshot.FinalUrl, &snapshot.Source, &snapshot.ContentType, &fetchedAt, &html); err != nil {
+			return nil, err
+		}
+
+		snapshot.FetchedAt = time.Unix(fetchedAt, 0)
+		if snapshot.Html, err = decompress(html); err != nil {
+			return nil, fmt.Errorf("snapshot %d: %v", snapshot.Id, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+func (s *SQLiteStore) PruneSnapshots(before time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM snapshots WHERE fetched_at < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// Title matches weigh twice as much as text matches
+func (s *SQLiteStore) Search(query string, limit int) ([]scraping.NewsItem, error) {
+	if !s.fts {
+		rows, err := s.db.Query("SELECT " + newsItemColumns + " FROM news_items")
+		if err != nil {
+			return nil, err
+		}
+
+		items, err := scanNewsItems(rows)
+		if err != nil {
+			return nil, err
+		}
+		return rankItems(items, query, limit), nil
+	}
+
+	match := matchQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query("SELECT "+newsItemColumns+" FROM news_items JOIN news_items_fts ON news_items_fts.rowid = news_items.id WHERE news_items_fts MATCH ? ORDER BY bm25(news_items_fts, 2.0, 1.0) LIMIT ?", match, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanNewsItems(rows)
+}
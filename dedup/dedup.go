@@ -0,0 +1,79 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// Number of paragraphs after the title taken into account. The rest of the text is usually specific to the source
+const fingerprintParagraphs = 3
+
+// Fingerprint returns the SimHash of the title and the first paragraphs. Near-duplicate texts have fingerprints within a small Hamming distance
+func Fingerprint(title string, text []string) uint64 {
+	content := title
+	for i := 0; i < len(text) && i < fingerprintParagraphs; i++ {
+		content += " " + text[i]
+	}
+
+	words := tokenize(content)
+	if len(words) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+
+	// Single words and word pairs as features, so both the vocabulary and the word order matter
+	for i := range words {
+		addFeature(&weights, words[i])
+		if i+1 < len(words) {
+			addFeature(&weights, words[i]+" "+words[i+1])
+		}
+	}
+
+	var fingerprint uint64
+	for i, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << uint(i)
+		}
+	}
+
+	return fingerprint
+}
+
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func addFeature(weights *[64]int, feature string) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+
+	for i := 0; i < 64; i++ {
+		if sum&(1<<uint(i)) != 0 {
+			weights[i]++
+		} else {
+			weights[i]--
+		}
+	}
+}
+
+// Short words are mostly prepositions and conjunctions, which don't tell anything about the story
+func tokenize(text string) []string {
+	var words []string
+
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, field := range fields {
+		field = strings.ReplaceAll(field, "ё", "е")
+		if len([]rune(field)) > 2 {
+			words = append(words, field)
+		}
+	}
+
+	return words
+}
@@ -0,0 +1,80 @@
+package dedup
+
+import "testing"
+
+var text = []string{
+	"Волонтёры приюта для бездомных животных нашли в лесу щенка, который три дня провёл без еды.",
+	"Щенка отвезли в ветеринарную клинику, после лечения он нашёл новых хозяев.",
+}
+
+const duplicateDistance = 3
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name   string
+		titleA string
+		textA  []string
+		titleB string
+		textB  []string
+		// Within the default -duplicate-distance
+		duplicate bool
+	}{
+		{
+			name:      "identical",
+			titleA:    "Волонтёры спасли щенка",
+			textA:     text,
+			titleB:    "Волонтёры спасли щенка",
+			textB:     text,
+			duplicate: true,
+		},
+		{
+			name:      "case, ё and punctuation",
+			titleA:    "Волонтёры спасли щенка",
+			textA:     text,
+			titleB:    "ВОЛОНТЕРЫ СПАСЛИ ЩЕНКА!",
+			textB:     text,
+			duplicate: true,
+		},
+		{
+			name:      "paragraphs after the first ones",
+			titleA:    "Волонтёры спасли щенка",
+			textA:     append(append([]string{}, text...), "Щенок прожил в приюте неделю.", "Фото: пресс-служба приюта."),
+			titleB:    "Волонтёры спасли щенка",
+			textB:     append(append([]string{}, text...), "Щенок прожил в приюте неделю.", "Подписывайтесь на наш канал."),
+			duplicate: true,
+		},
+		{
+			name:   "different story",
+			titleA: "Волонтёры спасли щенка",
+			textA:  text,
+			titleB: "В городе открыли новую библиотеку",
+			textB:  []string{"Библиотека на тысячу книг открылась в здании бывшего кинотеатра, читателей ждут каждый день."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Distance(Fingerprint(tt.titleA, tt.textA), Fingerprint(tt.titleB, tt.textB))
+			if duplicate := got <= duplicateDistance; duplicate != tt.duplicate {
+				t.Errorf("got distance %d, want duplicate %v", got, tt.duplicate)
+			}
+		})
+	}
+}
+
+// A small edit is not a duplicate with the default distance on a text this short, but stays much closer than another story
+func TestDistanceOfEdits(t *testing.T) {
+	original := Fingerprint("Волонтёры спасли щенка", text)
+	edited := Fingerprint("Волонтёры спасли щенка", []string{text[0], "Щенка отвезли в ветеринарную клинику, после лечения он обрёл новых хозяев."})
+	other := Fingerprint("В городе открыли новую библиотеку", []string{"Библиотека на тысячу книг открылась в здании бывшего кинотеатра, читателей ждут каждый день."})
+
+	if edit, another := Distance(original, edited), Distance(original, other); edit*2 >= another {
+		t.Errorf("got distance %d to the edited text, %d to another story", edit, another)
+	}
+}
+
+func TestFingerprintWithoutWords(t *testing.T) {
+	if got := Fingerprint("В и на", []string{"— 1 —"}); got != 0 {
+		t.Errorf("got %x, want 0", got)
+	}
+}
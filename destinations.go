@@ -0,0 +1,47 @@
+package main
+
+import (
+	"goodnews/external"
+	"goodnews/filtering"
+	"goodnews/language"
+	"goodnews/taxonomy"
+)
+
+// Destinations without the chat ID in their environment variable are skipped
+var destinations = []external.Destination{
+	{
+		Name:      "default",
+		ChatIdEnv: "CHAT_ID",
+		Languages: []string{language.Russian},
+	},
+	{
+		Name:      "pets",
+		ChatIdEnv: "PETS_CHAT_ID",
+		Languages: []string{language.Russian},
+		Filters: []filtering.Rule{
+			{
+				Name:       "animals only",
+				Action:     filtering.Allow,
+				Categories: []string{taxonomy.Animals},
+				Keywords:   []string{"кошк", "котен", "котят", "собак", "щен", "питом"},
+			},
+		},
+	},
+	{
+		Name:      "moscow",
+		ChatIdEnv: "MOSCOW_CHAT_ID",
+		Languages: []string{language.Russian},
+		Filters: []filtering.Rule{
+			{
+				Name:      "moscow region only",
+				Action:    filtering.Allow,
+				Locations: []string{"Москва", "Московская область"},
+			},
+		},
+	},
+	{
+		Name:        "english",
+		ChatIdEnv:   "EN_CHAT_ID",
+		TranslateTo: language.English,
+	},
+}
@@ -1,11 +1,22 @@
 package external
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"goodnews/filtering"
+	"goodnews/geo"
+	"goodnews/imaging"
+	"goodnews/language"
 	"goodnews/scraping"
+	"goodnews/summary"
+	"goodnews/taxonomy"
+	"goodnews/translation"
+	"html"
 	"io"
 	"log"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
@@ -13,59 +24,215 @@ import (
 	"time"
 )
 
-func SendToExternalService(item scraping.NewsItem) error {
+// Strategies of filling the caption with the news text
+const (
+	CaptionFirstParagraphs = "first-paragraphs"
+	CaptionTextRank        = "textrank"
+)
+
+type Config struct {
+	// Generated title card is uploaded as the photo for the items without a usable image
+	TitleCard       imaging.CardConfig
+	CaptionStrategy string
+	Destinations    []Destination
+	// Used for the destinations with TranslateTo
+	Translator translation.Translator
+}
+
+// Destination is a chat the items are sent to. The chat ID is read from the ChatIdEnv environment variable
+type Destination struct {
+	Name, ChatIdEnv string
+	// Evaluated before sending, e.g. to send only the items about animals to the pets chat
+	Filters []filtering.Rule
+	// Languages of the items sent to the chat, see the language package. All the languages if empty
+	Languages []string
+	// Items in other languages are translated to it before sending, e.g. for an English mirror of the channel
+	TranslateTo string
+}
+
+// Items in the unknown language, e.g. stored before the detection was added, are accepted by all the destinations
+func (d Destination) AcceptsLanguage(lang string) bool {
+	if len(d.Languages) == 0 || lang == language.Unknown {
+		return true
+	}
+	for _, l := range d.Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Destination) ChatId() string {
+	return os.Getenv(d.ChatIdEnv)
+}
+
+// SendToExternalService posts the item to the destination and returns the ID of the message
+func SendToExternalService(item scraping.NewsItem, destination Destination, cfg Config) (string, error) {
 	apiKey := os.Getenv("API_KEY")
-	chatId := os.Getenv("CHAT_ID")
+	chatId := destination.ChatId()
 
 	// As of now, Telegram API allows sendPhoto's caption parameter to contain up to 1024 charactes: https://core.telegram.org/bots/api#sendphoto
-	caption := assembleCaption(item, 900, false)
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendPhoto", apiKey)
+	caption := assembleCaption(item, 900, false, cfg.CaptionStrategy)
+
+	if len(item.Images) > 1 {
+		return sendMediaGroup(apiKey, chatId, item, caption)
+	}
+
+	params := url.Values{}
+
+	if item.Image == "" && cfg.TitleCard.Enabled {
+		card, err := imaging.RenderTitleCard(cfg.TitleCard, item.Title, scraping.SourceOf(item))
+		if err == nil {
+			params.Set("chat_id", chatId)
+			params.Set("caption", caption)
+			params.Set("parse_mode", "html")
+
+			return uploadToTelegram(apiKey, "sendPhoto", item, params, "photo", "card.png", card)
+		}
+		log.Printf("Error rendering the title card for item with ID %d: %v. Sending it without a photo", item.Id, err)
+	}
+
+	// The image didn't pass the check, so the post goes without a photo
+	if item.Image == "" {
+		params.Set("chat_id", chatId)
+		params.Set("text", caption)
+		params.Set("parse_mode", "html")
+
+		return callTelegram(apiKey, "sendMessage", item, params)
+	}
+
+	params.Set("chat_id", chatId)
+	params.Set("photo", item.Image)
+	params.Set("caption", caption)
+	params.Set("parse_mode", "html")
+
+	return callTelegram(apiKey, "sendPhoto", item, params)
+}
+
+// Telegram API allows 2-10 photos in a media group and shows the caption of the first one under the whole group: https://core.telegram.org/bots/api#sendmediagroup
+func sendMediaGroup(apiKey, chatId string, item scraping.NewsItem, caption string) (string, error) {
+	type inputMediaPhoto struct {
+		Type      string `json:"type"`
+		Media     string `json:"media"`
+		Caption   string `json:"caption,omitempty"`
+		ParseMode string `json:"parse_mode,omitempty"`
+	}
+
+	var media []inputMediaPhoto
 
-	resp, err := http.Get(fmt.Sprintf("%s?chat_id=%s&photo=%s&caption=%s&parse_mode=html", url, chatId, item.Image, caption))
+	for i, image := range item.Images {
+		if i == 10 {
+			break
+		}
+		photo := inputMediaPhoto{Type: "photo", Media: image}
+		if i == 0 {
+			photo.Caption = caption
+			photo.ParseMode = "html"
+		}
+		media = append(media, photo)
+	}
+
+	mediaJSON, err := json.Marshal(media)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
+	params := url.Values{}
+	params.Set("chat_id", chatId)
+	params.Set("media", string(mediaJSON))
+
+	return callTelegram(apiKey, "sendMediaGroup", item, params)
+}
+
+func callTelegram(apiKey, method string, item scraping.NewsItem, params url.Values) (string, error) {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/%s", apiKey, method)
+
+	resp, err := http.PostForm(url, params)
+	if err != nil {
+		return "", err
+	}
+
+	return checkTelegramResponse(resp, method, item, params)
+}
+
+func uploadToTelegram(apiKey, method string, item scraping.NewsItem, params url.Values, field, fileName string, file []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for key := range params {
+		if err := writer.WriteField(key, params.Get(key)); err != nil {
+			return "", err
 		}
-		log.Printf("Request: %s?chat_id=%s&photo=%s&caption=%s&parse_mode=html", url, chatId, item.Image, caption)
-		log.Printf("Response body:\n%s", body)
-		return fmt.Errorf("sendToExternalService request for item id: %d. StatusCode: %d", item.Id, resp.StatusCode)
 	}
+
+	part, err := writer.CreateFormFile(field, fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(file); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/%s", apiKey, method)
+
+	resp, err := http.Post(url, writer.FormDataContentType(), &body)
+	if err != nil {
+		return "", err
+	}
+
+	return checkTelegramResponse(resp, method, item, params)
+}
+
+func checkTelegramResponse(resp *http.Response, method string, item scraping.NewsItem, params url.Values) (string, error) {
 	defer resp.Body.Close()
-	return nil
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Request: %s %v", method, params)
+		log.Printf("Response body:\n%s", body)
+		return "", fmt.Errorf("sendToExternalService %s request for item id: %d. StatusCode: %d", method, item.Id, resp.StatusCode)
+	}
+
+	return messageId(body), nil
 }
 
-func pickRandomMessageEnding() string {
-	emoji := []string{
-		"\xF0\x9F\x98\x8A",
-		"\xF0\x9F\x98\x8F",
-		"\xF0\x9F\x91\x8C",
-		"\xF0\x9F\x91\x8D",
-		"\xF0\x9F\x91\x80",
-		"\xF0\x9F\x98\xB8",
-		"\xF0\x9F\x98\x81",
-		"\xF0\x9F\x98\x83",
-		"\xF0\x9F\x98\x87",
-		"\xF0\x9F\x98\x8E",
-		"\xF0\x9F\x9A\x80",
-		"\xE2\x9C\x8C",
-		"\xF0\x9F\x99\x8C",
-		"\xF0\x9F\x98\x82",
-		"\xF0\x9F\x98\x84",
-		"\xF0\x9F\x98\x85",
-		"\xF0\x9F\x98\x89",
-		"\xF0\x9F\x98\x8B",
-		"\xF0\x9F\x98\x8D",
-		"\xF0\x9F\x98\x9C",
-		"\xF0\x9F\x99\x8F",
-		"\xE2\x9A\xA1",
+// The result is the sent message, or the list of messages for sendMediaGroup. The ID of the first one is returned, empty if the response can't be parsed
+func messageId(body []byte) string {
+	type message struct {
+		MessageId int64 `json:"message_id"`
 	}
 
-	messageEndings := []string{
+	var response struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return ""
+	}
+
+	var single message
+	if err := json.Unmarshal(response.Result, &single); err == nil && single.MessageId != 0 {
+		return fmt.Sprint(single.MessageId)
+	}
+
+	var group []message
+	if err := json.Unmarshal(response.Result, &group); err == nil && len(group) > 0 {
+		return fmt.Sprint(group[0].MessageId)
+	}
+
+	return ""
+}
+
+// Items in the unknown languages get the Russian endings, as the channel is Russian
+var messageEndings = map[string][]string{
+	language.Russian: {
 		"Подписывайся! У нас только хорошие новости!",
 		"Жми сюда, если надоел Doom Scrolling",
 		"Если понравилось, заходи. У нас есть ещё!",
@@ -93,18 +260,126 @@ func pickRandomMessageEnding() string {
 		"Подними себе настроение каждый день!",
 		"Делай мир ярче, присоединяйся к нам!",
 		"С нами каждый день - праздник добрых новостей!",
+	},
+	language.Ukrainian: {
+		"Підписуйся! У нас лише добрі новини!",
+		"Тисни сюди, якщо набрид Doom Scrolling",
+		"Більше добрих новин на нашому каналі!",
+		"Не пропусти свою порцію позитиву!",
+		"Піднімай собі настрій щодня разом з нами!",
+	},
+	language.English: {
+		"Subscribe! We only post good news!",
+		"Tap here if you are tired of doom scrolling",
+		"More good news on our channel!",
+		"Don't miss your daily dose of positivity!",
+		"Join us and make the world brighter!",
+	},
+}
+
+// Captions are in the language of the item
+var authorLabels = map[string]string{
+	language.Russian:   "Автор",
+	language.Ukrainian: "Автор",
+	language.English:   "Author",
+}
+
+// Captions show the normalised category in the language of the item
+var categoryLabels = map[string]map[string]string{
+	language.Russian: {
+		taxonomy.Science:     "Наука",
+		taxonomy.Technology:  "Технологии",
+		taxonomy.Animals:     "Животные",
+		taxonomy.Nature:      "Природа",
+		taxonomy.Charity:     "Добрые дела",
+		taxonomy.Health:      "Здоровье",
+		taxonomy.Society:     "Общество",
+		taxonomy.Culture:     "Культура",
+		taxonomy.Sport:       "Спорт",
+		taxonomy.Discoveries: "Открытия",
+		taxonomy.Other:       "Разное",
+	},
+	language.Ukrainian: {
+		taxonomy.Science:     "Наука",
+		taxonomy.Technology:  "Технології",
+		taxonomy.Animals:     "Тварини",
+		taxonomy.Nature:      "Природа",
+		taxonomy.Charity:     "Добрі справи",
+		taxonomy.Health:      "Здоров'я",
+		taxonomy.Society:     "Суспільство",
+		taxonomy.Culture:     "Культура",
+		taxonomy.Sport:       "Спорт",
+		taxonomy.Discoveries: "Відкриття",
+		taxonomy.Other:       "Різне",
+	},
+	language.English: {
+		taxonomy.Science:     "Science",
+		taxonomy.Technology:  "Technology",
+		taxonomy.Animals:     "Animals",
+		taxonomy.Nature:      "Nature",
+		taxonomy.Charity:     "Charity",
+		taxonomy.Health:      "Health",
+		taxonomy.Society:     "Society",
+		taxonomy.Culture:     "Culture",
+		taxonomy.Sport:       "Sport",
+		taxonomy.Discoveries: "Discoveries",
+		taxonomy.Other:       "Other",
+	},
+}
+
+// Items stored before the taxonomy was introduced have only the raw category
+func categoryLabel(item scraping.NewsItem) string {
+	labels, ok := categoryLabels[item.Language]
+	if !ok {
+		labels = categoryLabels[language.Russian]
+	}
+	if label, ok := labels[item.NormalizedCategory]; ok {
+		return label
+	}
+	return item.Category
+}
+
+func pickRandomMessageEnding(lang string) string {
+	emoji := []string{
+		"\xF0\x9F\x98\x8A",
+		"\xF0\x9F\x98\x8F",
+		"\xF0\x9F\x91\x8C",
+		"\xF0\x9F\x91\x8D",
+		"\xF0\x9F\x91\x80",
+		"\xF0\x9F\x98\xB8",
+		"\xF0\x9F\x98\x81",
+		"\xF0\x9F\x98\x83",
+		"\xF0\x9F\x98\x87",
+		"\xF0\x9F\x98\x8E",
+		"\xF0\x9F\x9A\x80",
+		"\xE2\x9C\x8C",
+		"\xF0\x9F\x99\x8C",
+		"\xF0\x9F\x98\x82",
+		"\xF0\x9F\x98\x84",
+		"\xF0\x9F\x98\x85",
+		"\xF0\x9F\x98\x89",
+		"\xF0\x9F\x98\x8B",
+		"\xF0\x9F\x98\x8D",
+		"\xF0\x9F\x98\x9C",
+		"\xF0\x9F\x99\x8F",
+		"\xE2\x9A\xA1",
+	}
+
+	endings, ok := messageEndings[lang]
+	if !ok {
+		endings = messageEndings[language.Russian]
 	}
 
 	rand.New(rand.NewSource(time.Now().UnixNano()))
-	randomIndexMsg := rand.Intn(len(messageEndings))
+	randomIndexMsg := rand.Intn(len(endings))
 	randomIndexEmj := rand.Intn(len(emoji))
 
-	result := fmt.Sprintf("%v <a href='t.me/nomoredoomscrolling'>%v</a>", emoji[randomIndexEmj], messageEndings[randomIndexMsg])
+	result := fmt.Sprintf("%v <a href='t.me/nomoredoomscrolling'>%v</a>", emoji[randomIndexEmj], endings[randomIndexMsg])
 
 	return result
 }
 
-func assembleCaption(item scraping.NewsItem, maxLength int, postDatetime bool) string {
+func assembleCaption(item scraping.NewsItem, maxLength int, postDatetime bool, strategy string) string {
 	resultText := ""
 	caption := ""
 	newsText := ""
@@ -125,12 +400,29 @@ func assembleCaption(item scraping.NewsItem, maxLength int, postDatetime bool) s
 		resultText = item.P1
 	}
 
-	var dateTime string
+	var dateTime, author, hashtags string
 
 	if postDatetime {
 		dateTime = fmt.Sprintf("%s\n\n", item.Posted)
 	}
 
+	if len(item.Locations) > 0 {
+		var tags []string
+		for _, location := range item.Locations {
+			tags = append(tags, geo.Hashtag(location))
+		}
+		hashtags = strings.Join(tags, " ") + "\n\n"
+	}
+
+	// Credit the original writer according to our attribution policy
+	if item.Author != "" {
+		label, ok := authorLabels[item.Language]
+		if !ok {
+			label = authorLabels[language.Russian]
+		}
+		author = fmt.Sprintf("<i>%s: %s</i>\n\n", label, html.EscapeString(item.Author))
+	}
+
 	if len(resultText) > 0 && resultText[len(resultText)-1] != '\n' {
 		if len(item.P1) >= maxLength {
 			newsText = strings.Split(item.P1, "\n")[0]
@@ -141,9 +433,16 @@ func assembleCaption(item scraping.NewsItem, maxLength int, postDatetime bool) s
 		newsText = resultText
 	}
 
-	caption = fmt.Sprintf("<a href='%v'>%s: %s</a>\n\n%s\n\n%s%s", item.Url, item.Category, item.Title, newsText, dateTime, pickRandomMessageEnding())
+	// TextRank stems the words as Russian ones
+	if strategy == CaptionTextRank && (item.Language == language.Russian || item.Language == language.Unknown) {
+		if summarized := summary.TextRank(item.Text, maxLength); summarized != "" {
+			newsText = summarized
+		}
+	}
+
+	caption = fmt.Sprintf("<a href='%v'>%s: %s</a>\n\n%s\n\n%s%s%s%s", item.Url, categoryLabel(item), item.Title, newsText, author, hashtags, dateTime, pickRandomMessageEnding(item.Language))
 	caption = strings.ReplaceAll(caption, "\n\n\n", "\n\n")
 	caption = strings.ReplaceAll(caption, "*", "")
 
-	return url.QueryEscape(caption)
+	return caption
 }
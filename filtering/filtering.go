@@ -0,0 +1,130 @@
+package filtering
+
+import (
+	"fmt"
+	"goodnews/nlp"
+	"log"
+	"regexp"
+	"strings"
+)
+
+const (
+	Allow = "allow"
+	Block = "block"
+)
+
+// Rule matches if any of its keywords, regex, categories or locations matches. Keywords are word prefixes ("войн" matches "войны"),
+// keywords with spaces are matched as phrases. Regex is matched against the title and the text, case-insensitively.
+// Locations are the canonical names from the geo gazetteer
+type Rule struct {
+	Name, Action string
+	Keywords     []string
+	Regex        string
+	Categories   []string
+	Locations    []string
+}
+
+// Subject is what the rules are evaluated against
+type Subject struct {
+	Title      string
+	Text       []string
+	Categories []string
+	Locations  []string
+}
+
+type Decision struct {
+	Allowed bool
+	// Rule that made the decision, empty if there are no rules
+	Rule string
+}
+
+func (d Decision) String() string {
+	if d.Rule == "" {
+		return ""
+	}
+	if d.Allowed {
+		return fmt.Sprintf("%s:%s", Allow, d.Rule)
+	}
+	return fmt.Sprintf("%s:%s", Block, d.Rule)
+}
+
+// Evaluate applies the block rules first. If there are allow rules, at least one of them has to match, otherwise the subject is blocked
+func Evaluate(rules []Rule, subject Subject) Decision {
+	text := strings.Join(append([]string{subject.Title}, subject.Text...), "\n")
+	words := nlp.Words(text)
+	normalizedText := " " + strings.Join(words, " ") + " "
+
+	hasAllowRules := false
+
+	for _, rule := range rules {
+		if rule.Action == Allow {
+			hasAllowRules = true
+			continue
+		}
+		if rule.matches(text, normalizedText, words, subject) {
+			return Decision{Allowed: false, Rule: rule.Name}
+		}
+	}
+
+	if !hasAllowRules {
+		return Decision{Allowed: true}
+	}
+
+	for _, rule := range rules {
+		if rule.Action == Allow && rule.matches(text, normalizedText, words, subject) {
+			return Decision{Allowed: true, Rule: rule.Name}
+		}
+	}
+
+	return Decision{Allowed: false, Rule: "no allow rule matched"}
+}
+
+func (r Rule) matches(text, normalizedText string, words []string, subject Subject) bool {
+	for _, keyword := range r.Keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		keyword = strings.ReplaceAll(keyword, "ё", "е")
+		if keyword == "" {
+			continue
+		}
+
+		if strings.Contains(keyword, " ") {
+			if strings.Contains(normalizedText, " "+keyword) {
+				return true
+			}
+			continue
+		}
+
+		for _, word := range words {
+			if strings.HasPrefix(word, keyword) {
+				return true
+			}
+		}
+	}
+
+	if r.Regex != "" {
+		re, err := regexp.Compile("(?i)" + r.Regex)
+		if err != nil {
+			log.Printf("Error! Filter rule %s regex %s can't be compiled: %v. Proceeding without it", r.Name, r.Regex, err)
+		} else if re.MatchString(text) {
+			return true
+		}
+	}
+
+	for _, ruleCategory := range r.Categories {
+		for _, category := range subject.Categories {
+			if category != "" && strings.EqualFold(strings.TrimSpace(category), ruleCategory) {
+				return true
+			}
+		}
+	}
+
+	for _, ruleLocation := range r.Locations {
+		for _, location := range subject.Locations {
+			if strings.EqualFold(location, ruleLocation) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,145 @@
+package geo
+
+import (
+	_ "embed"
+	"goodnews/nlp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//go:embed gazetteer.txt
+var gazetteer string
+
+type token struct {
+	stem        string
+	capitalized bool
+	// First word of the title or of a sentence, which is capitalized anyway
+	sentenceStart bool
+}
+
+type entry struct {
+	name   string
+	tokens []token
+	// The name is a common word too, e.g. орёл (eagle), so it is only matched where a common word would not be capitalized
+	common bool
+}
+
+// Entries by the stem of their first token, the longest first
+var entries = make(map[string][]entry)
+
+func init() {
+	for _, line := range strings.Split(gazetteer, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		common := strings.HasPrefix(line, "?")
+		forms := strings.Split(strings.TrimPrefix(line, "?"), "|")
+		for _, form := range forms {
+			tokens := tokenize(form)
+			if len(tokens) == 0 {
+				continue
+			}
+			entries[tokens[0].stem] = append(entries[tokens[0].stem], entry{name: strings.TrimSpace(forms[0]), tokens: tokens, common: common})
+		}
+	}
+
+	for stem := range entries {
+		sort.SliceStable(entries[stem], func(i, j int) bool {
+			return len(entries[stem][i].tokens) > len(entries[stem][j].tokens)
+		})
+	}
+}
+
+// Tag returns the canonical names of the cities and regions mentioned in the title and the text, in the order of their first mention.
+// Names are matched by their stems, so inflected forms are found too, and have to be capitalized in the text.
+// The names which are common words too are skipped at the start of a sentence, where they can't be told apart
+func Tag(title string, text []string) []string {
+	var locations []string
+	seen := make(map[string]bool)
+
+	tokens := tokenize(strings.Join(append([]string{title}, text...), "\n"))
+
+	for i := 0; i < len(tokens); {
+		length := 0
+
+		for _, e := range entries[tokens[i].stem] {
+			if matches(tokens[i:], e) {
+				length = len(e.tokens)
+				if !seen[e.name] {
+					seen[e.name] = true
+					locations = append(locations, e.name)
+				}
+				break
+			}
+		}
+
+		if length == 0 {
+			length = 1
+		}
+		i += length
+	}
+
+	return locations
+}
+
+// Hashtag turns the location name into a Telegram hashtag: #Санкт_Петербург
+func Hashtag(location string) string {
+	return "#" + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, location)
+}
+
+func matches(tokens []token, e entry) bool {
+	if len(tokens) < len(e.tokens) || (e.common && tokens[0].sentenceStart) {
+		return false
+	}
+
+	properNoun := false
+	for i, t := range e.tokens {
+		if tokens[i].stem != t.stem {
+			return false
+		}
+		if t.capitalized && tokens[i].capitalized {
+			properNoun = true
+		}
+	}
+
+	return properNoun
+}
+
+func tokenize(text string) []token {
+	var tokens []token
+	var word []rune
+	sentenceStart := true
+
+	flush := func() {
+		w := strings.Trim(string(word), "-")
+		word = word[:0]
+		if w == "" {
+			return
+		}
+		lower := strings.ReplaceAll(strings.ToLower(w), "ё", "е")
+		tokens = append(tokens, token{stem: nlp.Stem(lower), capitalized: unicode.IsUpper([]rune(w)[0]), sentenceStart: sentenceStart})
+		sentenceStart = false
+	}
+
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		if strings.ContainsRune(".!?…\n", r) {
+			sentenceStart = true
+		}
+	}
+	flush()
+
+	return tokens
+}
@@ -0,0 +1,57 @@
+package geo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		text  []string
+		want  []string
+	}{
+		{
+			name:  "inflected forms",
+			title: "В Санкт-Петербурге открыли новый парк",
+			text:  []string{"Жители Москвы и Питера приехали на открытие."},
+			want:  []string{"Санкт-Петербург", "Москва"},
+		},
+		{
+			name:  "lowercase common word",
+			title: "Над полем кружил орёл",
+			text:  []string{"Его заметили грибники."},
+		},
+		{
+			name:  "common word at the start of a sentence",
+			title: "Орёл свил гнездо на вышке связи",
+			text:  []string{"Птицу заметили монтажники. Орёл высиживает птенцов."},
+		},
+		{
+			name:  "common word inside a sentence",
+			title: "В Орле открыли детский сад",
+			text:  []string{"Жители Орла ждали его пять лет."},
+			want:  []string{"Орёл"},
+		},
+		{
+			name:  "multi-word name",
+			title: "Волонтёры Курганской области собрали помощь приюту",
+			want:  []string{"Курганская область"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Tag(tt.title, tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashtag(t *testing.T) {
+	if got, want := Hashtag("Санкт-Петербург"), "#Санкт_Петербург"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
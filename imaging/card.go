@@ -0,0 +1,179 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+type CardConfig struct {
+	Enabled bool
+	// Optional PNG or JPEG background, scaled to Width x Height. Plain Background colour is used if empty
+	TemplatePath string
+	// Optional TTF or OTF font. Bundled Go Bold font (supports Cyrillic) is used if empty
+	FontPath                            string
+	Width, Height, Padding              int
+	TitleSize, SourceSize               float64
+	Background, TitleColor, SourceColor color.Color
+}
+
+func DefaultCardConfig() CardConfig {
+	return CardConfig{
+		Enabled:     true,
+		Width:       1280,
+		Height:      720,
+		Padding:     80,
+		TitleSize:   64,
+		SourceSize:  32,
+		Background:  color.RGBA{0x1e, 0x88, 0xe5, 0xff},
+		TitleColor:  color.White,
+		SourceColor: color.RGBA{0xff, 0xd5, 0x4f, 0xff},
+	}
+}
+
+// RenderTitleCard draws the title and the source name on the background and returns PNG bytes
+func RenderTitleCard(cfg CardConfig, title, source string) ([]byte, error) {
+	canvas := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+
+	if cfg.TemplatePath != "" {
+		background, err := loadImage(cfg.TemplatePath)
+		if err != nil {
+			return nil, fmt.Errorf("can't load the card template %s: %v", cfg.TemplatePath, err)
+		}
+		xdraw.CatmullRom.Scale(canvas, canvas.Bounds(), background, background.Bounds(), draw.Src, nil)
+	} else {
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(cfg.Background), image.Point{}, draw.Src)
+		// Accent line at the top as a part of the default branding
+		draw.Draw(canvas, image.Rect(0, 0, cfg.Width, cfg.Padding/4), image.NewUniform(cfg.SourceColor), image.Point{}, draw.Src)
+	}
+
+	fontData := gobold.TTF
+	if cfg.FontPath != "" {
+		var err error
+		fontData, err = os.ReadFile(cfg.FontPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't load the card font %s: %v", cfg.FontPath, err)
+		}
+	}
+
+	f, err := opentype.Parse(fontData)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceFace, err := opentype.NewFace(f, &opentype.FaceOptions{Size: cfg.SourceSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer sourceFace.Close()
+
+	textWidth := cfg.Width - 2*cfg.Padding
+	sourceHeight := sourceFace.Metrics().Height.Ceil()
+	titleAreaHeight := cfg.Height - 2*cfg.Padding - 2*sourceHeight
+
+	// Long titles get a smaller font until they fit
+	var titleFace font.Face
+	var lines []string
+	for size := cfg.TitleSize; ; size -= 4 {
+		titleFace, err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		lines = wrapText(titleFace, title, textWidth)
+		if len(lines)*titleFace.Metrics().Height.Ceil() <= titleAreaHeight || size <= 24 {
+			break
+		}
+		titleFace.Close()
+	}
+	defer titleFace.Close()
+
+	lineHeight := titleFace.Metrics().Height.Ceil()
+	y := cfg.Padding + (titleAreaHeight-len(lines)*lineHeight)/2 + titleFace.Metrics().Ascent.Ceil()
+
+	drawer := font.Drawer{Dst: canvas, Src: image.NewUniform(cfg.TitleColor), Face: titleFace}
+	for _, line := range lines {
+		if y > cfg.Padding+titleAreaHeight {
+			break
+		}
+		drawer.Dot = fixed.P(cfg.Padding, y)
+		drawer.DrawString(line)
+		y += lineHeight
+	}
+
+	drawer = font.Drawer{Dst: canvas, Src: image.NewUniform(cfg.SourceColor), Face: sourceFace}
+	drawer.Dot = fixed.P(cfg.Padding, cfg.Height-cfg.Padding)
+	drawer.DrawString(source)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseHexColor parses colours in #rrggbb or #rrggbbaa format
+func ParseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 6 {
+		s += "ff"
+	}
+	if len(s) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q, expected #rrggbb or #rrggbbaa", s)
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q: %v", s, err)
+	}
+
+	return color.RGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+func wrapText(face font.Face, text string, maxWidth int) []string {
+	var lines []string
+	var line string
+
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line != "" && font.MeasureString(face, candidate).Ceil() > maxWidth {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line = candidate
+		}
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func loadImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
@@ -0,0 +1,234 @@
+package imaging
+
+import (
+	"fmt"
+	"goodnews/scraping"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Results of the image check recorded on the item
+const (
+	StatusOK      = "ok"
+	StatusOgImage = "og_image"
+	StatusGallery = "gallery_image"
+	StatusNoImage = "no_image"
+)
+
+// As of now, Telegram downloads photos sent by URL up to 5 MB and doesn't accept WebP as a photo: https://core.telegram.org/bots/api#sending-files
+const telegramMaxPhotoBytes = 5 * 1024 * 1024
+
+var telegramPhotoTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+type Result struct {
+	Image, Status, Reason string
+	Images                []string
+}
+
+type Validator struct {
+	UserAgent    string
+	MaxBytes     int64
+	ContentTypes []string
+	Client       *http.Client
+}
+
+func NewValidator(userAgent string) *Validator {
+	return &Validator{
+		UserAgent:    userAgent,
+		MaxBytes:     telegramMaxPhotoBytes,
+		ContentTypes: telegramPhotoTypes,
+		Client:       &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Check verifies the item image, tries the og:image of the article as a fallback and otherwise returns an empty image so the item is posted without a photo
+func (v *Validator) Check(item scraping.NewsItem) Result {
+	var result Result
+
+	src, reason := v.checkImage(item.Url, item.Image)
+	if reason == "" {
+		result.Image = src
+		result.Status = StatusOK
+	} else {
+		log.Printf("Image %q of item with ID %d is not usable: %s. Trying og:image", item.Image, item.Id, reason)
+		ogImage, err := v.fetchOgImage(item.Url)
+		if err != nil {
+			reason = fmt.Sprintf("%s; og:image: %v", reason, err)
+		} else if ogSrc, ogReason := v.checkImage(item.Url, ogImage); ogReason == "" {
+			result.Image = ogSrc
+			result.Status = StatusOgImage
+			result.Reason = reason
+		} else {
+			reason = fmt.Sprintf("%s; og:image: %s", reason, ogReason)
+		}
+
+		if result.Image == "" {
+			result.Status = StatusNoImage
+			result.Reason = reason
+		}
+	}
+
+	if result.Image != "" {
+		result.Images = append(result.Images, result.Image)
+	}
+
+	for _, image := range item.Images {
+		if image == item.Image {
+			continue
+		}
+		src, reason := v.checkImage(item.Url, image)
+		if reason != "" {
+			log.Printf("Gallery image %q of item with ID %d is not usable: %s. Skipping it", image, item.Id, reason)
+			continue
+		}
+		result.Images = append(result.Images, src)
+	}
+
+	if result.Image == "" && len(result.Images) > 0 {
+		result.Image = result.Images[0]
+		result.Status = StatusGallery
+	}
+
+	return result
+}
+
+// Returns the absolute image url and an empty reason if the image can be sent
+func (v *Validator) checkImage(pageUrl, image string) (string, string) {
+	image = strings.TrimSpace(image)
+	if image == "" {
+		return "", "empty url"
+	}
+
+	src, err := resolveUrl(pageUrl, image)
+	if err != nil {
+		return "", fmt.Sprintf("invalid url: %v", err)
+	}
+
+	resp, err := v.request("HEAD", src)
+	// Some servers don't support HEAD requests, so we are asking for the first bytes of the image instead
+	if err != nil || resp.StatusCode == http.StatusMethodNotAllowed || resp.Header.Get("Content-Type") == "" {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		resp, err = v.request("GET", src)
+		if err != nil {
+			return "", fmt.Sprintf("request failed: %v", err)
+		}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return "", fmt.Sprintf("hotlink protected (status %d)", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		return "", fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if contentType == "" || contentType == "application/octet-stream" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(resp.Body, head)
+		contentType = http.DetectContentType(head[:n])
+	}
+
+	if !v.allowedContentType(contentType) {
+		return "", fmt.Sprintf("unsupported content type %s", contentType)
+	}
+
+	if size := contentSize(resp); v.MaxBytes > 0 && size > v.MaxBytes {
+		return "", fmt.Sprintf("too large (%d bytes)", size)
+	}
+
+	return src, ""
+}
+
+func (v *Validator) request(method, src string) (*http.Response, error) {
+	req, err := http.NewRequest(method, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", v.UserAgent)
+	if method == "GET" {
+		req.Header.Set("Range", "bytes=0-511")
+	}
+
+	return v.Client.Do(req)
+}
+
+func (v *Validator) allowedContentType(contentType string) bool {
+	for _, allowed := range v.ContentTypes {
+		if contentType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Validator) fetchOgImage(pageUrl string) (string, error) {
+	req, err := http.NewRequest("GET", pageUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", v.UserAgent)
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	ogImage, ok := doc.Find("meta[property=\"og:image\"]").Attr("content")
+	if !ok || strings.TrimSpace(ogImage) == "" {
+		return "", fmt.Errorf("not found")
+	}
+
+	return ogImage, nil
+}
+
+func contentSize(resp *http.Response) int64 {
+	// For the range requests the full size is in the Content-Range header: bytes 0-511/123456
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		var start, end, total int64
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err == nil {
+			return total
+		}
+	}
+
+	return resp.ContentLength
+}
+
+func resolveUrl(pageUrl, src string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	if !u.IsAbs() {
+		base, err := url.Parse(pageUrl)
+		if err != nil {
+			return "", err
+		}
+		u = base.ResolveReference(u)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,104 @@
+package language
+
+import (
+	"embed"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	Russian   = "ru"
+	Ukrainian = "uk"
+	English   = "en"
+	Unknown   = ""
+)
+
+// Number of the most frequent trigrams compared
+const profileSize = 300
+
+//go:embed profiles/*.txt
+var profileFiles embed.FS
+
+// Trigram ranks of each language, built from the sample texts in profiles/
+var profiles = make(map[string]map[string]int)
+
+func init() {
+	for _, lang := range []string{Russian, Ukrainian, English} {
+		data, err := profileFiles.ReadFile("profiles/" + lang + ".txt")
+		if err != nil {
+			panic(err)
+		}
+		profiles[lang] = ranks(string(data))
+	}
+}
+
+// Detect returns the language of the text using the out-of-place distance between trigram profiles (Cavnar and Trenkle, 1994).
+// Unknown is returned for the texts without letters
+func Detect(text string) string {
+	docRanks := ranks(text)
+	if len(docRanks) == 0 {
+		return Unknown
+	}
+
+	best, bestDistance := Unknown, -1
+	for _, lang := range []string{Russian, Ukrainian, English} {
+		distance := 0
+		for trigram, rank := range docRanks {
+			if profileRank, ok := profiles[lang][trigram]; ok {
+				distance += abs(rank - profileRank)
+			} else {
+				distance += profileSize
+			}
+		}
+
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = lang, distance
+		}
+	}
+
+	return best
+}
+
+func ranks(text string) map[string]int {
+	counts := make(map[string]int)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	for _, word := range words {
+		runes := []rune("_" + word + "_")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+
+	trigrams := make([]string, 0, len(counts))
+	for trigram := range counts {
+		trigrams = append(trigrams, trigram)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		if counts[trigrams[i]] != counts[trigrams[j]] {
+			return counts[trigrams[i]] > counts[trigrams[j]]
+		}
+		return trigrams[i] < trigrams[j]
+	})
+
+	result := make(map[string]int)
+	for i, trigram := range trigrams {
+		if i == profileSize {
+			break
+		}
+		result[trigram] = i
+	}
+
+	return result
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,40 @@
+package language
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "russian",
+			text: "Волонтёры приюта нашли в лесу щенка, который три дня провёл без еды",
+			want: Russian,
+		},
+		{
+			name: "ukrainian",
+			text: "Волонтери притулку знайшли в лісі цуценя, яке три дні провело без їжі",
+			want: Ukrainian,
+		},
+		{
+			name: "english",
+			text: "Shelter volunteers found a puppy in the woods that had spent three days without food",
+			want: English,
+		},
+		{
+			name: "no letters",
+			text: "12:00 — 2026",
+			want: Unknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.text); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
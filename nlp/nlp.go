@@ -0,0 +1,93 @@
+package nlp
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Words returns the lowercased words of the text, ё is replaced with е
+func Words(text string) []string {
+	text = strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+}
+
+// Terms returns the stems of the words of the text without stop words and one-letter words
+func Terms(text string) []string {
+	var terms []string
+
+	for _, word := range Words(text) {
+		word = strings.Trim(word, "-")
+		if len([]rune(word)) < 2 || IsStopWord(word) {
+			continue
+		}
+		terms = append(terms, Stem(word))
+	}
+
+	return terms
+}
+
+// SearchTerms returns the terms of the text with the fleeting vowel of the stems dropped, so the search matches щенок with щенка and отец with отца
+func SearchTerms(text string) []string {
+	terms := Terms(text)
+	for i, term := range terms {
+		terms[i] = dropFleetingVowel(term)
+	}
+	return terms
+}
+
+// The nominative of the nouns in -ок, -ек and -ец loses the vowel in the other forms. It is dropped from every stem with such an ending,
+// so the forms which keep it, e.g. урок and урока, still share the term
+func dropFleetingVowel(stem string) string {
+	w := []rune(stem)
+	n := len(w)
+	if n < 4 || isVowel(w[n-3]) || (w[n-2] != 'о' && w[n-2] != 'е') || (w[n-1] != 'к' && w[n-1] != 'ц') {
+		return stem
+	}
+	return string(append(w[:n-2:n-2], w[n-1]))
+}
+
+// Sentences splits the text into sentences on ., ! and ? followed by a space and a capital letter, a digit or a quote
+func Sentences(text string) []string {
+	var sentences []string
+	runes := []rune(strings.TrimSpace(text))
+	start := 0
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '.' && runes[i] != '!' && runes[i] != '?' && runes[i] != '…' {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && strings.ContainsRune(".!?…»\")", runes[end]) {
+			end++
+		}
+		if end < len(runes) && !unicode.IsSpace(runes[end]) {
+			continue
+		}
+
+		next := end
+		for next < len(runes) && unicode.IsSpace(runes[next]) {
+			next++
+		}
+		if next < len(runes) && !unicode.IsUpper(runes[next]) && !unicode.IsDigit(runes[next]) && !strings.ContainsRune("«\"—-", runes[next]) {
+			continue
+		}
+
+		if sentence := strings.TrimSpace(string(runes[start:end])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = next
+		i = next - 1
+	}
+
+	if start < len(runes) {
+		if sentence := strings.TrimSpace(string(runes[start:])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+
+	return sentences
+}
@@ -0,0 +1,30 @@
+package nlp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		forms []string
+	}{
+		{name: "fleeting о", forms: []string{"щенок", "щенка", "щенки", "щенков"}},
+		{name: "fleeting е", forms: []string{"отец", "отца", "отцом"}},
+		{name: "kept vowel", forms: []string{"урок", "урока", "уроки"}},
+		{name: "ё", forms: []string{"ёлка", "елки"}},
+		{name: "short stem", forms: []string{"сок", "сока"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := strings.Join(SearchTerms(tt.forms[0]), " ")
+			for _, form := range tt.forms[1:] {
+				if got := strings.Join(SearchTerms(form), " "); got != want {
+					t.Errorf("got %q for %s, want %q as for %s", got, form, want, tt.forms[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package nlp
+
+import "strings"
+
+// Russian Snowball stemmer: https://snowballstem.org/algorithms/russian/stemmer.html
+
+var (
+	perfectiveGerund1 = []string{"вшись", "вши", "в"}
+	perfectiveGerund2 = []string{"ившись", "ывшись", "ивши", "ывши", "ив", "ыв"}
+	adjective         = []string{"ими", "ыми", "его", "ого", "ему", "ому", "ее", "ие", "ые", "ое", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею"}
+	participle1       = []string{"ем", "нн", "вш", "ющ", "щ"}
+	participle2       = []string{"ивш", "ывш", "ующ"}
+	reflexive         = []string{"ся", "сь"}
+	verb1             = []string{"ете", "йте", "ешь", "нно", "ла", "на", "ли", "ем", "ло", "но", "ет", "ют", "ны", "ть", "й", "л", "н"}
+	verb2             = []string{"ейте", "уйте", "ила", "ыла", "ена", "ите", "или", "ыли", "ило", "ыло", "ено", "ует", "уют", "ены", "ить", "ыть", "ишь", "ей", "уй", "ил", "ыл", "им", "ым", "ен", "ят", "ит", "ыт", "ую", "ю"}
+	noun              = []string{"иями", "ями", "ами", "ией", "иям", "ием", "иях", "ев", "ов", "ие", "ье", "еи", "ии", "ей", "ой", "ий", "ям", "ем", "ам", "ом", "ах", "ях", "ию", "ью", "ия", "ья", "а", "е", "и", "й", "о", "у", "ы", "ь", "ю", "я"}
+	superlative       = []string{"ейше", "ейш"}
+	derivational      = []string{"ость", "ост"}
+)
+
+func isVowel(r rune) bool {
+	return strings.ContainsRune("аеиоуыэюя", r)
+}
+
+// Stem returns the stem of a lowercased Russian word. Other words are returned as is
+func Stem(word string) string {
+	w := []rune(strings.ReplaceAll(word, "ё", "е"))
+	rv, r2 := regions(w)
+	if rv < 0 {
+		return word
+	}
+
+	// Step 1
+	if n := findEnding(w, rv, perfectiveGerund2, false); n > 0 {
+		w = w[:len(w)-n]
+	} else if n := findEnding(w, rv, perfectiveGerund1, true); n > 0 {
+		w = w[:len(w)-n]
+	} else {
+		if n := findEnding(w, rv, reflexive, false); n > 0 {
+			w = w[:len(w)-n]
+		}
+		if n := findAdjectival(w, rv); n > 0 {
+			w = w[:len(w)-n]
+		} else if n := findVerb(w, rv); n > 0 {
+			w = w[:len(w)-n]
+		} else if n := findEnding(w, rv, noun, false); n > 0 {
+			w = w[:len(w)-n]
+		}
+	}
+
+	// Step 2
+	if len(w) > rv && w[len(w)-1] == 'и' {
+		w = w[:len(w)-1]
+	}
+
+	// Step 3
+	if n := findEnding(w, r2, derivational, false); n > 0 {
+		w = w[:len(w)-n]
+	}
+
+	// Step 4
+	doubleN := func(w []rune) bool {
+		return len(w)-2 >= rv && w[len(w)-1] == 'н' && w[len(w)-2] == 'н'
+	}
+	if n := findEnding(w, rv, superlative, false); n > 0 {
+		w = w[:len(w)-n]
+		if doubleN(w) {
+			w = w[:len(w)-1]
+		}
+	} else if doubleN(w) {
+		w = w[:len(w)-1]
+	} else if len(w) > rv && w[len(w)-1] == 'ь' {
+		w = w[:len(w)-1]
+	}
+
+	return string(w)
+}
+
+// RV is the region after the first vowel, R2 is the R1 region of R1. -1 for rv means there are no vowels
+func regions(w []rune) (int, int) {
+	rv := -1
+	for i, r := range w {
+		if isVowel(r) {
+			rv = i + 1
+			break
+		}
+	}
+
+	r1 := len(w)
+	for i := 1; i < len(w); i++ {
+		if !isVowel(w[i]) && isVowel(w[i-1]) {
+			r1 = i + 1
+			break
+		}
+	}
+
+	r2 := len(w)
+	for i := r1 + 1; i < len(w); i++ {
+		if !isVowel(w[i]) && isVowel(w[i-1]) {
+			r2 = i + 1
+			break
+		}
+	}
+
+	return rv, r2
+}
+
+// Returns the length of the longest ending in the region. Endings of the first group must be preceded by а or я in the region
+func findEnding(w []rune, region int, endings []string, afterAYa bool) int {
+	best := 0
+
+	for _, ending := range endings {
+		e := []rune(ending)
+		start := len(w) - len(e)
+		if len(e) <= best || start < region || string(w[start:]) != ending {
+			continue
+		}
+		if afterAYa && (start-1 < region || (w[start-1] != 'а' && w[start-1] != 'я')) {
+			continue
+		}
+		best = len(e)
+	}
+
+	return best
+}
+
+func findAdjectival(w []rune, rv int) int {
+	n := findEnding(w, rv, adjective, false)
+	if n == 0 {
+		return 0
+	}
+
+	rest := w[:len(w)-n]
+	if p := findEnding(rest, rv, participle2, false); p > 0 {
+		return n + p
+	}
+	if p := findEnding(rest, rv, participle1, true); p > 0 {
+		return n + p
+	}
+
+	return n
+}
+
+func findVerb(w []rune, rv int) int {
+	n1 := findEnding(w, rv, verb1, true)
+	n2 := findEnding(w, rv, verb2, false)
+	if n1 > n2 {
+		return n1
+	}
+	return n2
+}
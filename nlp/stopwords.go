@@ -0,0 +1,28 @@
+package nlp
+
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, word := range []string{
+		"а", "без", "более", "бы", "был", "была", "были", "было", "быть", "в", "вам", "вас", "весь", "во", "вот",
+		"все", "всего", "всех", "вы", "где", "да", "даже", "для", "до", "его", "ее", "ей", "ему", "если", "есть",
+		"еще", "же", "за", "здесь", "и", "из", "или", "им", "их", "к", "как", "какой", "когда", "кто", "ли",
+		"либо", "мне", "может", "мы", "на", "надо", "наш", "не", "него", "нее", "нет", "ни", "них", "но", "ну",
+		"о", "об", "однако", "он", "она", "они", "оно", "от", "очень", "по", "под", "при", "с", "со", "так",
+		"также", "такой", "там", "те", "тем", "то", "того", "тоже", "той", "только", "том", "ты", "у", "уже",
+		"хотя", "чего", "чей", "чем", "что", "чтобы", "чье", "чья", "эта", "эти", "это", "этого", "этой", "этом",
+		"этот", "я", "себя", "свой", "своей", "своих", "свою", "который", "которая", "которые", "которых",
+		"которой", "котором", "этих", "всё", "ещё", "её", "после", "через", "между", "перед",
+		"около", "кроме", "вместо", "будет", "будут", "является", "являются", "сейчас", "теперь",
+		"ведь", "лишь", "именно", "свои", "своего", "своим", "нам", "нас", "ним", "ними", "неё",
+		"раз", "два", "один", "одна", "одно", "тот", "та", "ту", "тех", "тому", "всем", "всеми", "всю",
+		"вся", "сам", "сама", "само", "сами", "самый", "мой", "моя", "мое", "мои", "твой", "ваш", "наши",
+		"ваши", "где-то", "кто-то", "что-то", "как-то", "почему", "зачем", "потому", "поэтому", "пока",
+	} {
+		stopWords[word] = true
+	}
+}
+
+func IsStopWord(word string) bool {
+	return stopWords[word]
+}
@@ -0,0 +1,89 @@
+package pagecheck
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pages with less text than this are not articles, but a listing, a stub or an error page
+const DefaultMinTextLength = 200
+
+// Paths of the category, tag, author, search and pagination pages of the common CMSes
+var DefaultListingPatterns = []string{
+	`/(tag|tags|category|categories|rubric|rubrika|rubriki|author|authors|search|page)(/|$)`,
+	`[?&](page|paged|s|q|tag|cat)=`,
+}
+
+// Phrases of the "page not found" templates returned with status 200
+var notFoundPhrases = []string{
+	"страница не найдена",
+	"страница не существует",
+	"страница удалена",
+	"ошибка 404",
+	"сторінку не знайдено",
+	"page not found",
+	"404 not found",
+}
+
+// Config is set per source. Zero values mean the defaults
+type Config struct {
+	MinTextLength int
+	// Regexes of the urls of non-article pages, checked in addition to DefaultListingPatterns
+	ListingPatterns []string
+	// Some sources don't show the date of the articles
+	DateOptional bool
+}
+
+// Page is what was found on the page with the selectors of the source
+type Page struct {
+	Url, Title, Posted string
+	Text               []string
+	// Content of <meta name="robots">
+	Robots string
+}
+
+// Check returns an empty reason if the page looks like an article, otherwise the reason why it doesn't
+func Check(page Page, cfg Config) string {
+	if strings.Contains(strings.ToLower(page.Robots), "noindex") {
+		return fmt.Sprintf("noindex (robots: %q)", page.Robots)
+	}
+
+	for _, pattern := range append(DefaultListingPatterns, cfg.ListingPatterns...) {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			log.Printf("Error! Listing pattern %s can't be compiled: %v. Proceeding without it", pattern, err)
+			continue
+		}
+		if re.MatchString(page.Url) {
+			return fmt.Sprintf("url matches the listing pattern %s", pattern)
+		}
+	}
+
+	title := strings.ToLower(page.Title)
+	for _, phrase := range notFoundPhrases {
+		if strings.Contains(title, phrase) {
+			return fmt.Sprintf("not found page (title: %q)", page.Title)
+		}
+	}
+
+	if page.Posted == "" && !cfg.DateOptional {
+		return "missing date"
+	}
+
+	minTextLength := cfg.MinTextLength
+	if minTextLength == 0 {
+		minTextLength = DefaultMinTextLength
+	}
+	textLength := 0
+	for _, paragraph := range page.Text {
+		textLength += utf8.RuneCountInString(paragraph)
+	}
+	if textLength < minTextLength {
+		return fmt.Sprintf("too little text (%d characters, at least %d expected)", textLength, minTextLength)
+	}
+
+	return ""
+}
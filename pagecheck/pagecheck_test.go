@@ -0,0 +1,93 @@
+package pagecheck
+
+import (
+	"strings"
+	"testing"
+)
+
+var articleText = []string{
+	"Волонтёры приюта для бездомных животных нашли в лесу щенка, который три дня провёл без еды.",
+	"Щенка отвезли в ветеринарную клинику, после лечения он нашёл новых хозяев.",
+	"Теперь его зовут Бим, и он живёт в загородном доме с большим садом.",
+}
+
+func articlePage() Page {
+	return Page{
+		Url:    "https://example.com/news/1",
+		Title:  "Волонтёры спасли щенка",
+		Posted: "19-10-2026 12:00:00",
+		Text:   articleText,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Page)
+		cfg    Config
+		// Prefix of the reason, empty for an article
+		want string
+	}{
+		{
+			name:   "article",
+			change: func(p *Page) {},
+		},
+		{
+			name:   "noindex",
+			change: func(p *Page) { p.Robots = "NOINDEX, follow" },
+			want:   "noindex",
+		},
+		{
+			name:   "tag page",
+			change: func(p *Page) { p.Url = "https://example.com/tag/animals/" },
+			want:   "url matches the listing pattern",
+		},
+		{
+			name:   "pagination",
+			change: func(p *Page) { p.Url = "https://example.com/news?page=2" },
+			want:   "url matches the listing pattern",
+		},
+		{
+			name:   "source listing pattern",
+			change: func(p *Page) { p.Url = "https://example.com/digest/2026-10" },
+			cfg:    Config{ListingPatterns: []string{`/digest/`}},
+			want:   "url matches the listing pattern /digest/",
+		},
+		{
+			name:   "not found page",
+			change: func(p *Page) { p.Title = "Ошибка 404 — страница не найдена" },
+			want:   "not found page",
+		},
+		{
+			name:   "missing date",
+			change: func(p *Page) { p.Posted = "" },
+			want:   "missing date",
+		},
+		{
+			name:   "optional date",
+			change: func(p *Page) { p.Posted = "" },
+			cfg:    Config{DateOptional: true},
+		},
+		{
+			name:   "too little text",
+			change: func(p *Page) { p.Text = p.Text[:1] },
+			want:   "too little text",
+		},
+		{
+			name:   "source min text length",
+			change: func(p *Page) { p.Text = p.Text[:1] },
+			cfg:    Config{MinTextLength: 50},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := articlePage()
+			tt.change(&page)
+			got := Check(page, tt.cfg)
+			if (tt.want == "") != (got == "") || !strings.HasPrefix(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,508 @@
+package pipeline
+
+import (
+	"fmt"
+	"goodnews/database"
+	"goodnews/dedup"
+	"goodnews/external"
+	"goodnews/filtering"
+	"goodnews/imaging"
+	"goodnews/language"
+	"goodnews/scraping"
+	"goodnews/translation"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Items are compared with the fingerprints of the items posted during this period
+const duplicateWindowDays = 7
+
+// The delivery is failed after this number of unsuccessful attempts and is not retried
+const maxDeliveryAttempts = 5
+
+// Time a run has to send a claimed delivery and record the result
+const deliveryLease = 10 * time.Minute
+
+// InsertItems stores the items which are not older than newsAgeDays. An unsent item with the same url is updated, one sent to any destination is left as is.
+// Near-duplicates of the recent items, including the ones inserted before them in the same run, are stored, but marked so they are not sent
+func InsertItems(store database.Store, items []scraping.NewsItem, newsAgeDays, duplicateDistance int) error {
+	fingerprints, err := recentFingerprints(store, duplicateDistance)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if _, err := insertItem(store, item, newsAgeDays, duplicateDistance, &fingerprints); err != nil {
+			log.Printf("Error processing item with URL '%s': %v", item.Url, err)
+		}
+	}
+
+	return nil
+}
+
+// The fingerprints are read once per run, the inserted items are added as they go
+func recentFingerprints(store database.Store, duplicateDistance int) ([]database.ItemFingerprint, error) {
+	if duplicateDistance < 0 {
+		return nil, nil
+	}
+	return store.RecentFingerprints(time.Now().AddDate(0, 0, -duplicateWindowDays))
+}
+
+// Returns the status of the upsert, or an empty one if the item is too old to store
+func insertItem(store database.Store, item scraping.NewsItem, newsAgeDays, duplicateDistance int, fingerprints *[]database.ItemFingerprint) (string, error) {
+	postedTime, err := scraping.ParsePosted(item.Posted)
+	if err != nil {
+		return "", err
+	}
+
+	if time.Since(postedTime).Hours()/24 > float64(newsAgeDays) {
+		return "", nil
+	}
+
+	item.Fingerprint = dedup.Fingerprint(item.Title, item.Text)
+	item.DuplicateOf = findNearDuplicate(*fingerprints, item.Fingerprint, duplicateDistance)
+
+	result, err := store.UpsertItem(item)
+	if err != nil {
+		return "", err
+	}
+
+	// The item could have been rejected before, e.g. by a broken selector
+	if result.Status != database.Skipped {
+		if err := store.DeleteRejectedItem(item.Url); err != nil {
+			log.Printf("Error deleting the rejection of item with URL '%s': %v", item.Url, err)
+		}
+	}
+
+	switch result.Status {
+	case database.Inserted:
+		log.Printf("Item with URL '%s' inserted into the database.", item.Url)
+		if item.DuplicateOf > 0 {
+			log.Printf("Item with URL '%s' is a near-duplicate of the item with ID %d and won't be sent.", item.Url, item.DuplicateOf)
+		} else if item.Fingerprint != 0 {
+			*fingerprints = append(*fingerprints, database.ItemFingerprint{Id: result.Id, Fingerprint: item.Fingerprint})
+		}
+	case database.Updated:
+		log.Printf("Unsent item with URL '%s' updated in the database.", item.Url)
+	case database.Skipped:
+		log.Printf("Item with URL '%s' was already sent and is skipped.", item.Url)
+	}
+
+	return result.Status, nil
+}
+
+// Returns the ID of a recent item with a fingerprint within duplicateDistance, or 0. Negative duplicateDistance turns the check off
+func findNearDuplicate(fingerprints []database.ItemFingerprint, fingerprint uint64, duplicateDistance int) int {
+	if duplicateDistance < 0 || fingerprint == 0 {
+		return 0
+	}
+
+	for _, recent := range fingerprints {
+		if dedup.Distance(fingerprint, recent.Fingerprint) <= duplicateDistance {
+			return recent.Id
+		}
+	}
+
+	return 0
+}
+
+// ProcessUnsentItems sends the unsent items to the destinations allowed by the filters, the oldest first. Each item gets a delivery per destination,
+// so a failed one is retried on the next runs without posting the item to the other destinations again. Each delivery is claimed before sending
+// and recorded right after, so neither a crash nor another instance running at the same time can post the item twice. Items older than newsAgeDays are left unsent,
+// so the ones kept back by the sentiment threshold or the filters are not posted later if those change. In a dry run, the items are only logged
+func ProcessUnsentItems(dryRun bool, store database.Store, reqSleepMs, newsAgeDays int, sources []scraping.ScrapeEntity, cfg external.Config) error {
+	expired, err := store.ExpireClaims()
+	if err != nil {
+		return err
+	}
+	for _, delivery := range expired {
+		log.Printf("Delivery of item with ID %d to destination %s was interrupted while sending and won't be retried, check if the message was posted", delivery.ItemId, delivery.Destination)
+	}
+
+	unsentItems, err := store.UnsentItems(time.Now().AddDate(0, 0, -newsAgeDays))
+	if err != nil {
+		return err
+	}
+
+	if len(unsentItems) == 0 {
+		log.Println("No unsent items found...")
+		return nil
+	}
+
+	sort.Slice(unsentItems, func(i, j int) bool {
+		timeI, _ := scraping.ParsePosted(unsentItems[i].Posted)
+		timeJ, _ := scraping.ParsePosted(unsentItems[j].Posted)
+		return timeI.Before(timeJ)
+	})
+
+	validator := imaging.NewValidator(scraping.PickRandomUserAgent())
+
+	for _, item := range unsentItems {
+		if blocked, threshold := isBlockedBySentiment(sources, item); blocked {
+			log.Printf("Item with ID %d has the sentiment score %.2f below the threshold %.2f of its source and won't be sent", item.Id, item.Sentiment, threshold)
+			continue
+		}
+
+		// The rules could have changed since the item was scraped, so they are evaluated again
+		destinations, filterRule := filterDestinations(sources, cfg.Destinations, item)
+		if filterRule != "" {
+			if err := store.UpdateFilterRule(item.Id, filterRule); err != nil {
+				log.Printf("Error updating filter_rule for item with ID %d: %v", item.Id, err)
+			}
+		}
+		if len(destinations) == 0 {
+			log.Printf("Item with ID %d is blocked by the filter rules (%s) and won't be sent", item.Id, filterRule)
+			continue
+		}
+
+		pending, err := pendingDestinations(store, item.Id, destinations)
+		if err != nil {
+			log.Printf("Error reading deliveries for item with ID %d: %v", item.Id, err)
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		// A dry run makes no requests, so the images are not checked
+		if !dryRun {
+			result := validator.Check(item)
+			item.Image, item.Images, item.ImageStatus = result.Image, result.Images, result.Status
+			if result.Reason != "" {
+				log.Printf("Image check for item with ID %d: %s (%s)", item.Id, result.Status, result.Reason)
+			}
+
+			if err := store.UpdateImageCheck(item.Id, database.ImageCheck{Image: result.Image, Images: result.Images, Status: result.Status}); err != nil {
+				log.Printf("Error updating image_status for item with ID %d: %v", item.Id, err)
+			}
+		}
+
+		for _, destination := range destinations {
+			if !pending[destination.Name] {
+				continue
+			}
+
+			delivery, ok, err := store.ClaimDelivery(item.Id, destination.Name, deliveryLease)
+			if err != nil {
+				log.Printf("Error claiming delivery of item with ID %d to destination %s: %v", item.Id, destination.Name, err)
+				continue
+			}
+			if !ok {
+				log.Printf("Delivery of item with ID %d to destination %s is claimed by another run", item.Id, destination.Name)
+				continue
+			}
+
+			messageId, err := deliver(dryRun, store, cfg, item, destination)
+
+			delivery.Attempts++
+			delivery.Status = database.DeliveryPending
+			if err != nil {
+				log.Printf("Error sending item with ID %d to destination %s (attempt %d): %v", item.Id, destination.Name, delivery.Attempts, err)
+				delivery.LastError = err.Error()
+				if delivery.Attempts >= maxDeliveryAttempts {
+					delivery.Status = database.DeliveryFailed
+				}
+			} else {
+				delivery.Status, delivery.MessageId, delivery.SentAt = database.DeliverySent, messageId, scraping.FormatPosted(time.Now())
+			}
+
+			if err := store.SaveDelivery(delivery); err != nil {
+				log.Printf("Error saving delivery of item with ID %d to destination %s: %v", item.Id, destination.Name, err)
+			}
+
+			if !dryRun {
+				time.Sleep(time.Duration(reqSleepMs) * time.Millisecond)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the names of the destinations with pending deliveries of the item. On the first run of the item, a pending delivery is stored for each destination,
+// so the destinations which were not reached before a crash still get it. Later, the destinations added since then are not sent the old items
+func pendingDestinations(store database.Store, id int, destinations []external.Destination) (map[string]bool, error) {
+	stored, err := store.Deliveries(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stored) == 0 {
+		for _, destination := range destinations {
+			// Another run could have added it meanwhile
+			if err := store.AddDelivery(database.Delivery{ItemId: id, Destination: destination.Name, Status: database.DeliveryPending}); err != nil {
+				return nil, err
+			}
+		}
+
+		stored, err = store.Deliveries(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pending := make(map[string]bool)
+	for _, delivery := range stored {
+		if delivery.Status == database.DeliveryPending {
+			pending[delivery.Destination] = true
+		}
+	}
+	return pending, nil
+}
+
+// Sends the item to the destination, translated if needed, and returns the ID of the message
+func deliver(dryRun bool, store database.Store, cfg external.Config, item scraping.NewsItem, destination external.Destination) (string, error) {
+	translate := destination.TranslateTo != "" && destination.TranslateTo != item.Language
+
+	if dryRun {
+		if translate {
+			log.Printf("DRY-RUN: Item with ID %d '%s' would be translated to %s and sent to destination %s", item.Id, item.Title, destination.TranslateTo, destination.Name)
+		} else {
+			log.Printf("DRY-RUN: Item with ID %d '%s' would be sent to destination %s", item.Id, item.Title, destination.Name)
+		}
+		return "", nil
+	}
+
+	if translate {
+		translated, err := translateItem(store, cfg.Translator, item, destination.TranslateTo)
+		if err != nil {
+			return "", fmt.Errorf("translating to %s: %v", destination.TranslateTo, err)
+		}
+		item = translated
+	}
+
+	return external.SendToExternalService(item, destination, cfg)
+}
+
+func isBlockedBySentiment(sources []scraping.ScrapeEntity, item scraping.NewsItem) (bool, float64) {
+	entity := scraping.FindEntity(sources, item)
+	if entity == nil || entity.MinSentiment == nil {
+		return false, 0
+	}
+
+	return item.Sentiment < *entity.MinSentiment, *entity.MinSentiment
+}
+
+type BlockedItem struct {
+	Item      scraping.NewsItem
+	Threshold float64
+}
+
+// SentimentReport returns the unsent items not older than newsAgeDays blocked by the sentiment threshold of their source, the most positive first
+func SentimentReport(store database.Store, newsAgeDays int, sources []scraping.ScrapeEntity) ([]BlockedItem, error) {
+	items, err := store.UnsentItems(time.Now().AddDate(0, 0, -newsAgeDays))
+	if err != nil {
+		return nil, err
+	}
+
+	var blockedItems []BlockedItem
+	for _, item := range items {
+		if blocked, threshold := isBlockedBySentiment(sources, item); blocked {
+			blockedItems = append(blockedItems, BlockedItem{Item: item, Threshold: threshold})
+		}
+	}
+
+	sort.Slice(blockedItems, func(i, j int) bool {
+		return blockedItems[i].Item.Sentiment > blockedItems[j].Item.Sentiment
+	})
+
+	return blockedItems, nil
+}
+
+type ReprocessReport struct {
+	Snapshots, Inserted, Updated, Sent, TooOld, Rejected int
+}
+
+// Reprocess runs the extraction again on the latest snapshot of each article of the source fetched since the time, e.g. after a selector was fixed.
+// The stored items which were not sent yet are updated, the rejections are recorded again. The items which were not stored, e.g. rejected
+// by the broken selector, are inserted like the scraped ones if they are not older than newsAgeDays
+func Reprocess(store database.Store, scraper *scraping.Scraper, source string, since time.Time, newsAgeDays, duplicateDistance int) (ReprocessReport, error) {
+	var report ReprocessReport
+
+	snapshots, err := store.Snapshots(source, since)
+	if err != nil {
+		return report, err
+	}
+
+	latest := make(map[string]int)
+	var unique []scraping.Snapshot
+	for _, snapshot := range snapshots {
+		if i, ok := latest[snapshot.Url]; ok {
+			unique[i] = snapshot
+			continue
+		}
+		latest[snapshot.Url] = len(unique)
+		unique = append(unique, snapshot)
+	}
+
+	report.Snapshots = len(unique)
+	if len(unique) == 0 {
+		return report, nil
+	}
+
+	items, rejectedItems, err := scraper.Reprocess(unique)
+	if err != nil {
+		return report, err
+	}
+
+	for _, rejected := range rejectedItems {
+		report.Rejected++
+		if err := store.InsertRejectedItem(rejected); err != nil {
+			log.Printf("Error inserting rejected item: %v", err)
+		}
+	}
+
+	fingerprints, err := recentFingerprints(store, duplicateDistance)
+	if err != nil {
+		return report, err
+	}
+
+	for _, item := range items {
+		// The item is updated under its stored url, even if the canonical url of the page changed with the fixed selectors
+		storedUrl, err := store.StoredUrl(item)
+		if err != nil {
+			return report, err
+		}
+
+		if storedUrl == "" {
+			status, err := insertItem(store, item, newsAgeDays, duplicateDistance, &fingerprints)
+			if err != nil {
+				return report, err
+			}
+			switch status {
+			case "":
+				report.TooOld++
+			case database.Inserted:
+				report.Inserted++
+			case database.Updated:
+				// Only if another run stored it meanwhile
+				report.Updated++
+			case database.Skipped:
+				report.Sent++
+			}
+			continue
+		}
+		item.Url = storedUrl
+
+		item.Fingerprint = dedup.Fingerprint(item.Title, item.Text)
+		result, err := store.UpsertItem(item)
+		if err != nil {
+			return report, err
+		}
+
+		switch result.Status {
+		case database.Skipped:
+			report.Sent++
+		case database.Updated:
+			report.Updated++
+			log.Printf("Item with URL '%s' updated from the snapshot.", item.Url)
+			if err := store.DeleteRejectedItem(item.Url); err != nil {
+				log.Printf("Error deleting the rejection of item with URL '%s': %v", item.Url, err)
+			}
+		default:
+			// Only if the item was deleted meanwhile
+			return report, fmt.Errorf("item with URL '%s' was inserted instead of updated", item.Url)
+		}
+	}
+
+	return report, nil
+}
+
+type SearchResult struct {
+	Item       scraping.NewsItem
+	Deliveries []database.Delivery
+}
+
+// Search returns up to limit stored items matching all the words of the query in any form with their deliveries, the most relevant first.
+// It helps to find out if a story was already covered
+func Search(store database.Store, query string, limit int) ([]SearchResult, error) {
+	items, err := store.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, item := range items {
+		deliveries, err := store.Deliveries(item.Id)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{Item: item, Deliveries: deliveries})
+	}
+
+	return results, nil
+}
+
+// Returns the destinations allowed by the source and destination filters, and the rules that made the decisions
+func filterDestinations(sources []scraping.ScrapeEntity, destinations []external.Destination, item scraping.NewsItem) ([]external.Destination, string) {
+	var allowed []external.Destination
+	var rules []string
+	subject := scraping.FilterSubject(item)
+
+	if entity := scraping.FindEntity(sources, item); entity != nil {
+		decision := filtering.Evaluate(entity.Filters, subject)
+		if decision.Rule != "" {
+			rules = append(rules, "source:"+decision.String())
+		}
+		if !decision.Allowed {
+			return nil, strings.Join(rules, "; ")
+		}
+	}
+
+	for _, destination := range destinations {
+		if !destination.AcceptsLanguage(item.Language) {
+			rules = append(rules, fmt.Sprintf("%s:language %s", destination.Name, item.Language))
+			continue
+		}
+		decision := filtering.Evaluate(destination.Filters, subject)
+		if decision.Rule != "" {
+			rules = append(rules, destination.Name+":"+decision.String())
+		}
+		if decision.Allowed {
+			allowed = append(allowed, destination)
+		}
+	}
+
+	return allowed, strings.Join(rules, "; ")
+}
+
+// Returns the item with the category, title and text in the target language. Translations are stored, so each item is translated to each language once
+func translateItem(store database.Store, translator translation.Translator, item scraping.NewsItem, target string) (scraping.NewsItem, error) {
+	translated, ok, err := store.Translation(item.Id, target)
+	if err != nil {
+		return item, err
+	}
+
+	if !ok {
+		if translator == nil {
+			return item, fmt.Errorf("no translator configured")
+		}
+
+		source := item.Language
+		if source == language.Unknown {
+			source = "auto"
+		}
+
+		texts, err := translator.Translate(append([]string{item.Category, item.Title}, item.Text...), source, target)
+		if err != nil {
+			return item, err
+		}
+
+		translated = database.Translation{Language: target, Category: texts[0], Title: texts[1], Text: texts[2:]}
+		if err := store.SaveTranslation(item.Id, translated); err != nil {
+			return item, err
+		}
+	}
+
+	item.Category, item.Title, item.Text, item.Language = translated.Category, translated.Title, translated.Text, target
+	item.P1 = ""
+	if len(item.Text) > 1 {
+		item.P1 = item.Text[0] + " " + item.Text[1]
+	} else if len(item.Text) == 1 {
+		item.P1 = item.Text[0]
+	}
+
+	return item, nil
+}
@@ -0,0 +1,457 @@
+package pipeline
+
+import (
+	"errors"
+	"goodnews/database"
+	"goodnews/external"
+	"goodnews/filtering"
+	"goodnews/language"
+	"goodnews/scraping"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testText = []string{
+	"Волонтёры приюта для бездомных животных нашли в лесу щенка, который три дня провёл без еды.",
+	"Щенка отвезли в ветеринарную клинику, после лечения он нашёл новых хозяев.",
+}
+
+func testItem(url, title string) scraping.NewsItem {
+	return scraping.NewsItem{
+		Url:      url,
+		Title:    title,
+		Text:     testText,
+		Posted:   scraping.FormatPosted(time.Now().Add(-time.Hour)),
+		Aliases:  []string{url},
+		Language: language.Russian,
+	}
+}
+
+func TestInsertItems(t *testing.T) {
+	old := testItem("https://example.com/news/old", "Волонтёры спасли щенка")
+	old.Posted = scraping.FormatPosted(time.Now().AddDate(0, 0, -10))
+
+	tests := []struct {
+		name     string
+		stored   []scraping.NewsItem
+		sent     bool
+		item     scraping.NewsItem
+		wantLen  int
+		want     scraping.NewsItem
+		wantDupe bool
+	}{
+		{
+			name:    "new item",
+			item:    testItem("https://example.com/news/1", "Волонтёры спасли щенка"),
+			wantLen: 1,
+			want:    testItem("https://example.com/news/1", "Волонтёры спасли щенка"),
+		},
+		{
+			name:    "unsent item is updated",
+			stored:  []scraping.NewsItem{testItem("https://example.com/news/1", "Старый заголовок")},
+			item:    testItem("https://example.com/news/1", "Волонтёры спасли щенка"),
+			wantLen: 1,
+			want:    testItem("https://example.com/news/1", "Волонтёры спасли щенка"),
+		},
+		{
+			name:    "sent item is skipped",
+			stored:  []scraping.NewsItem{testItem("https://example.com/news/1", "Старый заголовок")},
+			sent:    true,
+			item:    testItem("https://example.com/news/1", "Волонтёры спасли щенка"),
+			wantLen: 1,
+			want:    testItem("https://example.com/news/1", "Старый заголовок"),
+		},
+		{
+			name:    "item served under another url is updated",
+			stored:  []scraping.NewsItem{testItem("https://example.com/news/1?from=rss", "Старый заголовок")},
+			item:    testItem("https://www.example.com/news/1/", "Волонтёры спасли щенка"),
+			wantLen: 1,
+			want:    testItem("https://example.com/news/1?from=rss", "Волонтёры спасли щенка"),
+		},
+		{
+			name:     "near-duplicate from another source",
+			stored:   []scraping.NewsItem{testItem("https://example.com/news/1", "Волонтёры спасли щенка")},
+			item:     testItem("https://example.org/news/2", "Волонтёры спасли щенка"),
+			wantLen:  2,
+			want:     testItem("https://example.org/news/2", "Волонтёры спасли щенка"),
+			wantDupe: true,
+		},
+		{
+			name: "old item is not stored",
+			item: old,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryStore()
+			// The stored items have the canonical url of the article as an alias
+			for i := range tt.stored {
+				tt.stored[i].Aliases = []string{"https://example.com/news/1"}
+			}
+			if err := InsertItems(store, tt.stored, 2, 3); err != nil {
+				t.Fatal(err)
+			}
+			if tt.sent {
+				if err := store.SaveDelivery(database.Delivery{ItemId: 1, Destination: "default", Status: database.DeliverySent}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := InsertItems(store, []scraping.NewsItem{tt.item}, 2, 3); err != nil {
+				t.Fatal(err)
+			}
+
+			items := store.Items()
+			if len(items) != tt.wantLen {
+				t.Fatalf("got %d items, want %d", len(items), tt.wantLen)
+			}
+			if tt.wantLen == 0 {
+				return
+			}
+
+			got := items[len(items)-1]
+			if got.Url != tt.want.Url || got.Title != tt.want.Title {
+				t.Errorf("got %s %q, want %s %q", got.Url, got.Title, tt.want.Url, tt.want.Title)
+			}
+			if (got.DuplicateOf != 0) != tt.wantDupe {
+				t.Errorf("got DuplicateOf %d, want a duplicate: %t", got.DuplicateOf, tt.wantDupe)
+			}
+		})
+	}
+}
+
+type failingTranslator struct{}
+
+func (failingTranslator) Translate(texts []string, source, target string) ([]string, error) {
+	return nil, errors.New("translation server is down")
+}
+
+func TestProcessUnsentItems(t *testing.T) {
+	// The image check falls back to the og:image of the article, which is served locally
+	site := httptest.NewServer(http.NotFoundHandler())
+	defer site.Close()
+
+	english := external.Destination{Name: "english", TranslateTo: language.English}
+
+	tests := []struct {
+		name string
+		// Called before the runs, e.g. to claim the delivery by another run
+		setup        func(store *database.MemoryStore)
+		dryRun       bool
+		runs         int
+		wantStatus   string
+		wantAttempts int
+		wantError    string
+	}{
+		{
+			name:         "dry run sends the item",
+			dryRun:       true,
+			runs:         1,
+			wantStatus:   database.DeliverySent,
+			wantAttempts: 1,
+		},
+		{
+			name:         "failed delivery is retried",
+			runs:         2,
+			wantStatus:   database.DeliveryPending,
+			wantAttempts: 2,
+			wantError:    "translating to en: translation server is down",
+		},
+		{
+			name:         "delivery fails after the last attempt",
+			runs:         maxDeliveryAttempts + 1,
+			wantStatus:   database.DeliveryFailed,
+			wantAttempts: maxDeliveryAttempts,
+			wantError:    "translating to en: translation server is down",
+		},
+		{
+			name: "delivery claimed by another run is skipped",
+			setup: func(store *database.MemoryStore) {
+				store.AddDelivery(database.Delivery{ItemId: 1, Destination: english.Name, Status: database.DeliveryPending})
+				store.ClaimDelivery(1, english.Name, deliveryLease)
+			},
+			dryRun:     true,
+			runs:       1,
+			wantStatus: database.DeliverySending,
+		},
+		{
+			name: "expired claim is failed and not retried",
+			setup: func(store *database.MemoryStore) {
+				store.AddDelivery(database.Delivery{ItemId: 1, Destination: english.Name, Status: database.DeliveryPending})
+				store.ClaimDelivery(1, english.Name, -time.Minute)
+			},
+			dryRun:     true,
+			runs:       1,
+			wantStatus: database.DeliveryFailed,
+			wantError:  "interrupted while sending, the message may have been posted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryStore()
+			if _, err := store.UpsertItem(testItem(site.URL+"/news/1", "Волонтёры спасли щенка")); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+
+			cfg := external.Config{Destinations: []external.Destination{english}, Translator: failingTranslator{}}
+			for i := 0; i < tt.runs; i++ {
+				if err := ProcessUnsentItems(tt.dryRun, store, 0, 2, nil, cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deliveries, err := store.Deliveries(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) != 1 {
+				t.Fatalf("got %d deliveries, want 1", len(deliveries))
+			}
+
+			got := deliveries[0]
+			if got.Status != tt.wantStatus || got.Attempts != tt.wantAttempts || got.LastError != tt.wantError {
+				t.Errorf("got %s after %d attempts (%q), want %s after %d attempts (%q)", got.Status, got.Attempts, got.LastError, tt.wantStatus, tt.wantAttempts, tt.wantError)
+			}
+
+			// Dry runs make no requests, so the images are not checked
+			if _, checked := store.ImageCheck(1); checked == tt.dryRun && tt.wantAttempts > 0 {
+				t.Errorf("got image checked: %t in a dry run: %t", checked, tt.dryRun)
+			}
+		})
+	}
+}
+
+func TestKeptBackItemsAreNotSentLater(t *testing.T) {
+	threshold, lowered := 0.0, -1.0
+	blocked := scraping.ScrapeEntity{SourceUrl: "https://example.com/", SourceName: "Example", Filters: []filtering.Rule{{Name: "no accidents", Action: filtering.Block, Keywords: []string{"авари"}}}}
+	grim := scraping.ScrapeEntity{SourceUrl: "https://example.com/", SourceName: "Example", MinSentiment: &threshold}
+	lowerThreshold := func(source *scraping.ScrapeEntity) { source.MinSentiment = &lowered }
+	dropFilters := func(source *scraping.ScrapeEntity) { source.Filters = nil }
+
+	tests := []struct {
+		name   string
+		source scraping.ScrapeEntity
+		// How long ago the item was posted
+		age time.Duration
+		// Changes the source after the first run, so the item is no longer kept back
+		change  func(source *scraping.ScrapeEntity)
+		wantLen int
+	}{
+		{
+			name:    "recent item is sent after the threshold is lowered",
+			source:  grim,
+			age:     time.Hour,
+			change:  lowerThreshold,
+			wantLen: 1,
+		},
+		{
+			name:   "old item is not sent after the threshold is lowered",
+			source: grim,
+			age:    3 * 24 * time.Hour,
+			change: lowerThreshold,
+		},
+		{
+			name:    "recent item is sent after the filter is removed",
+			source:  blocked,
+			age:     time.Hour,
+			change:  dropFilters,
+			wantLen: 1,
+		},
+		{
+			name:   "old item is not sent after the filter is removed",
+			source: blocked,
+			age:    3 * 24 * time.Hour,
+			change: dropFilters,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := []scraping.ScrapeEntity{tt.source}
+			cfg := external.Config{Destinations: []external.Destination{{Name: "default"}}}
+
+			item := testItem("https://example.com/news/1", "Авария на трассе")
+			item.Posted = scraping.FormatPosted(time.Now().Add(-tt.age))
+			item.Sentiment = -0.5
+
+			store := database.NewMemoryStore()
+			if _, err := store.UpsertItem(item); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ProcessUnsentItems(true, store, 0, 2, sources, cfg); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(&sources[0])
+			if err := ProcessUnsentItems(true, store, 0, 2, sources, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			deliveries, err := store.Deliveries(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) != tt.wantLen {
+				t.Errorf("got %d deliveries, want %d", len(deliveries), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestReprocess(t *testing.T) {
+	source := scraping.ScrapeEntity{
+		SourceUrl:  "https://example.com/",
+		SourceName: "Example",
+		ScrapeNewsHTMLElements: scraping.ScrapeNewsHTML{
+			TextTxt:      "article p",
+			CategoryTxt:  "a.category",
+			PostedAttr:   []string{"time", "datetime"},
+			PostedFormat: time.RFC3339,
+			TitleTxt:     "h1",
+			ImageAttr:    []string{"article img", "src"},
+		},
+	}
+	page := func(posted time.Time) []byte {
+		return []byte(`<html><head><title>Волонтёры спасли щенка</title></head><body><h1>Волонтёры спасли щенка</h1><a class="category">Животные</a>
+			<time datetime="` + posted.Format(time.RFC3339) + `"></time><article><img src="/image.jpg"><p>` + testText[0] + `</p><p>` + testText[1] + `</p><p>` + testText[0] + `</p></article></body></html>`)
+	}
+
+	tests := []struct {
+		name string
+		// Stored before reprocessing, e.g. with the text cut by a broken selector
+		stored   []scraping.NewsItem
+		rejected bool
+		posted   time.Time
+		want     ReprocessReport
+	}{
+		{
+			name:     "rejected item is inserted",
+			rejected: true,
+			posted:   time.Now().Add(-time.Hour),
+			want:     ReprocessReport{Snapshots: 1, Inserted: 1},
+		},
+		{
+			name:   "stored item is updated",
+			stored: []scraping.NewsItem{testItem("https://example.com/news/1", "Волонтёры")},
+			posted: time.Now().Add(-time.Hour),
+			want:   ReprocessReport{Snapshots: 1, Updated: 1},
+		},
+		{
+			name:     "old rejected item is not inserted",
+			rejected: true,
+			posted:   time.Now().AddDate(0, 0, -3),
+			want:     ReprocessReport{Snapshots: 1, TooOld: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const url = "https://example.com/news/1"
+
+			store := database.NewMemoryStore()
+			if err := InsertItems(store, tt.stored, 2, 3); err != nil {
+				t.Fatal(err)
+			}
+			if tt.rejected {
+				store.InsertRejectedItem(scraping.RejectedItem{Item: scraping.NewsItem{Url: url, Source: "Example"}})
+			}
+			store.SaveSnapshot(scraping.Snapshot{Url: url, FinalUrl: url, Source: "Example", ContentType: "text/html; charset=utf-8", FetchedAt: time.Now(), Html: page(tt.posted)})
+
+			scraper := scraping.NewScraper("test", []scraping.ScrapeEntity{source}, 0, false)
+			report, err := Reprocess(store, scraper, "Example", time.Now().Add(-time.Hour), 2, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report != tt.want {
+				t.Errorf("got %+v, want %+v", report, tt.want)
+			}
+
+			items := store.Items()
+			if want := tt.want.Inserted + tt.want.Updated; len(items) != want {
+				t.Fatalf("got %d items, want %d", len(items), want)
+			}
+			if len(items) > 0 && items[0].Title != "Волонтёры спасли щенка" {
+				t.Errorf("got title %q", items[0].Title)
+			}
+			if _, ok := store.Rejected(url); ok != (tt.rejected && len(items) == 0) {
+				t.Errorf("got the rejection kept: %t", ok)
+			}
+		})
+	}
+}
+
+func TestFilterDestinations(t *testing.T) {
+	sources := []scraping.ScrapeEntity{{
+		SourceUrl:  "https://example.com/",
+		SourceName: "Example",
+		Filters:    []filtering.Rule{{Name: "no accidents", Action: filtering.Block, Keywords: []string{"авари"}}},
+	}}
+	all := external.Destination{Name: "all"}
+	pets := external.Destination{Name: "pets", Filters: []filtering.Rule{{Name: "animals", Action: filtering.Allow, Categories: []string{"Animals"}}}}
+	english := external.Destination{Name: "english", Languages: []string{language.English}}
+	destinations := []external.Destination{all, pets, english}
+
+	item := func(title, category, lang string) scraping.NewsItem {
+		return scraping.NewsItem{Url: "https://example.com/news/1", Source: "Example", Title: title, NormalizedCategory: category, Language: lang}
+	}
+
+	tests := []struct {
+		name      string
+		item      scraping.NewsItem
+		want      []string
+		wantRules string
+	}{
+		{
+			name:      "blocked by the source",
+			item:      item("Авария на трассе", "Society", language.Russian),
+			wantRules: "source:block:no accidents",
+		},
+		{
+			name:      "allowed by the category",
+			item:      item("Волонтёры спасли щенка", "Animals", language.Russian),
+			want:      []string{"all", "pets"},
+			wantRules: "pets:allow:animals; english:language ru",
+		},
+		{
+			name:      "no allow rule matched",
+			item:      item("Открылся новый парк", "Society", language.Russian),
+			want:      []string{"all"},
+			wantRules: "pets:block:no allow rule matched; english:language ru",
+		},
+		{
+			name:      "unknown language is accepted",
+			item:      item("Открылся новый парк", "Society", language.Unknown),
+			want:      []string{"all", "english"},
+			wantRules: "pets:block:no allow rule matched",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, rules := filterDestinations(sources, destinations, tt.item)
+
+			var got []string
+			for _, destination := range allowed {
+				got = append(got, destination.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got destinations %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got destinations %v, want %v", got, tt.want)
+				}
+			}
+			if rules != tt.wantRules {
+				t.Errorf("got rules %q, want %q", rules, tt.wantRules)
+			}
+		})
+	}
+}
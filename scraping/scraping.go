@@ -1,27 +1,107 @@
 package scraping
 
 import (
+	"encoding/json"
 	"fmt"
+	"goodnews/charset"
+	"goodnews/classifier"
+	"goodnews/filtering"
+	"goodnews/geo"
+	"goodnews/language"
+	"goodnews/pagecheck"
+	"goodnews/sentiment"
+	"goodnews/taxonomy"
+	"goodnews/urlnorm"
+	"goodnews/validation"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"log"
 	"math/rand"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/gocolly/colly"
 )
 
+// Layout of NewsItem.Posted and the other dates stored as text
+const PostedLayout = "02-01-2006 15:04:05"
+
+// Time zone of the dates in PostedLayout, the local time of the sources
+const PostedTimeZone = "Europe/Moscow"
+
+// The zone is embedded with time/tzdata, as the image the bot runs in may have no zoneinfo
+var PostedLocation = mustLoadLocation(PostedTimeZone)
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+// FormatPosted formats the time in PostedLayout in PostedLocation
+func FormatPosted(t time.Time) string {
+	return t.In(PostedLocation).Format(PostedLayout)
+}
+
+// ParsePosted parses a date in PostedLayout as the time in PostedLocation
+func ParsePosted(posted string) (time.Time, error) {
+	return time.ParseInLocation(PostedLayout, posted, PostedLocation)
+}
+
 type NewsItem struct {
 	Id                                      int
 	Url, Category, Posted, Title, Image, P1 string
-	Text                                    []string
+	NormalizedCategory, Author, ImageStatus string
+	Source                                  string
+	Text, Images, Locations                 []string
+	// Canonical forms of all the urls of the item, the item is deduplicated by them. Url is the one the site served
+	Aliases     []string
+	Fingerprint uint64
+	DuplicateOf int
+	Sentiment   float64
+	// Detected with the language package, empty if unknown
+	Language string
+	// Charset the page was served in before transcoding to UTF-8
+	Charset string
+	// Filter rules that made the decisions about the item, for auditing
+	FilterRule string
+	// How NormalizedCategory was set: taxonomy.ByRule, taxonomy.ByDefault or taxonomy.ByClassifier
+	CategorySource string
 }
 
 type ScrapeEntity struct {
-	SourceUrl              string
+	SourceUrl, SourceName  string
 	ScrapeNewsUrlsElements ScrapeNewsURL
 	ScrapeNewsHTMLElements ScrapeNewsHTML
+	CategoryMapping        taxonomy.Mapping
+	// Query parameters stripped from the urls in addition to urlnorm.DefaultStripParams. Names ending with * are prefixes
+	StripQueryParams []string
+	// Items with the sentiment score below it are not sent. The --min-sentiment default is used if nil, so 0 can be set explicitly
+	MinSentiment *float64
+	// Evaluated after scraping and again before sending
+	Filters []filtering.Rule
+	// Heuristics rejecting the pages which are not articles, e.g. tag pages or "page not found" templates
+	PageCheck pagecheck.Config
+	// Charset of the pages, e.g. "windows-1251", for the sources where the detection fails. Detected if empty
+	Charset string
+	// Fields checked by the validation package, validation.DefaultRequired if empty. Add validation.Image to skip the items without an image
+	RequiredFields []string
+}
+
+// RejectedItem is an item which failed the page check or the validation, kept for review
+type RejectedItem struct {
+	Item       NewsItem
+	Rejections []validation.Rejection
 }
 
 type ScrapeNewsURL struct {
@@ -36,6 +116,17 @@ type ScrapeNewsHTML struct {
 	TextTxt, CategoryTxt, PostedFormat, TitleTxt string
 	PostedAttr, ImageAttr                        []string
 	PostedTextToParse                            TextToParse
+	// Optional. If empty or nothing was found, the author meta tag and JSON-LD author are used instead
+	AuthorTxt string
+	// Optional. Selector and attribute of the images of a photo series. ImageAttr image always goes first
+	GalleryAttr []string
+	// Max number of images per item, 0 means no limit. Images smaller than GalleryMinSize px on any side are skipped
+	GalleryLimit, GalleryMinSize int
+}
+
+type galleryImage struct {
+	src           string
+	width, height int
 }
 
 type Scraper struct {
@@ -44,23 +135,47 @@ type Scraper struct {
 	ReqSleepMs     int
 	DebugFlag      bool
 	ScrapeEntities []ScrapeEntity
+	// Optional. Predicts the category of the items with missing or unmapped categories
+	Classifier *classifier.Model
+	// Predictions with a lower probability are ignored
+	MinClassifierProbability float64
+	// Optional. Called with the page of each article fetch, so the extraction can be re-run later
+	OnSnapshot func(Snapshot)
+	// Keeps the pages as they were served for the snapshots
+	raw *rawTransport
 }
 
 func NewScraper(userAgent string, ScrapeEntities []ScrapeEntity, reqSleepMs int, debug bool) *Scraper {
 	c := colly.NewCollector()
 	c.UserAgent = userAgent
 
+	raw := &rawTransport{}
+	c.WithTransport(&charset.Transport{Base: raw, Overrides: charsetOverrides(ScrapeEntities)})
+
 	return &Scraper{
 		UserAgent:      userAgent,
 		Collector:      c,
 		ReqSleepMs:     reqSleepMs,
 		DebugFlag:      debug,
 		ScrapeEntities: ScrapeEntities,
+		raw:            raw,
+	}
+}
+
+// Charsets of the sources which set them, per host
+func charsetOverrides(entities []ScrapeEntity) map[string]string {
+	overrides := make(map[string]string)
+	for _, entity := range entities {
+		if entity.Charset != "" {
+			overrides[urlnorm.Host(entity.SourceUrl)] = entity.Charset
+		}
 	}
+	return overrides
 }
 
+// Returns the urls of the articles as the sources link them, without the ones with the same canonical form
 func (s *Scraper) ScrapeNewsUrlsFromSources() []string {
-	var newsUrls []string
+	var newsUrls, canonicalUrls []string
 
 	for i := 0; i < len(s.ScrapeEntities); i++ {
 
@@ -70,14 +185,19 @@ func (s *Scraper) ScrapeNewsUrlsFromSources() []string {
 
 		for _, htmlElement := range s.ScrapeEntities[i].ScrapeNewsUrlsElements.UrlElements {
 			s.Collector.OnHTML(htmlElement, func(e *colly.HTMLElement) {
-				newsUrls = append(newsUrls, e.ChildAttr("a", "href"))
+				href := e.ChildAttr("a", "href")
+				if href != "" {
+					newsUrl := e.Request.AbsoluteURL(href)
+					newsUrls = append(newsUrls, newsUrl)
+					canonicalUrls = append(canonicalUrls, urlnorm.Canonicalize(newsUrl, s.ScrapeEntities[i].StripQueryParams...))
+				}
 			})
 			s.Collector.Visit(s.ScrapeEntities[i].SourceUrl)
 			time.Sleep(time.Duration(s.ReqSleepMs) * time.Millisecond)
 		}
 	}
 
-	newsUrls = s.removeDuplicatesAndRootSite(newsUrls)
+	newsUrls = s.removeDuplicatesAndRootSite(newsUrls, canonicalUrls)
 
 	if s.DebugFlag {
 		log.Printf("DEBUG: newsUrls: %v", newsUrls)
@@ -86,14 +206,20 @@ func (s *Scraper) ScrapeNewsUrlsFromSources() []string {
 	return newsUrls
 }
 
-func (s *Scraper) ScrapeNewsFromNewsUrls(newsUrls []string) ([]NewsItem, error) {
+// Returns the valid items and the rejected ones
+func (s *Scraper) ScrapeNewsFromNewsUrls(newsUrls []string) ([]NewsItem, []RejectedItem, error) {
 	if len(newsUrls) == 0 {
-		return nil, fmt.Errorf("the NewsUrls is empty. Please make sure to run scraper.ScrapeNewsUrlsFromSources() first")
+		return nil, nil, fmt.Errorf("the NewsUrls is empty. Please make sure to run scraper.ScrapeNewsUrlsFromSources() first")
 	} else {
 		var newsItems []NewsItem
+		var rejectedItems []RejectedItem
 
 		for i := 0; i < len(newsUrls); i++ {
 			var newsItem NewsItem
+			var metaAuthor, jsonLDAuthor string
+			var gallery []galleryImage
+			var finalUrl, canonicalUrl, robots string
+			var page rawPage
 			newsItem.Url = newsUrls[i]
 
 			for j := 0; j < len(s.ScrapeEntities); j++ {
@@ -103,7 +229,12 @@ func (s *Scraper) ScrapeNewsFromNewsUrls(newsUrls []string) ([]NewsItem, error)
 					break
 				}
 
-				if strings.Contains(newsUrls[i], u.Scheme+"://"+u.Host) {
+				if urlnorm.Host(newsUrls[i]) == urlnorm.Host(u.String()) {
+					newsItem.Source = s.ScrapeEntities[j].SourceName
+					if newsItem.Source == "" {
+						newsItem.Source = u.Host
+					}
+
 					s.Collector.OnError(func(_ *colly.Response, err error) {
 						log.Println("Something went wrong: ", err)
 					})
@@ -140,6 +271,55 @@ func (s *Scraper) ScrapeNewsFromNewsUrls(newsUrls []string) ([]NewsItem, error)
 					s.Collector.OnHTML(s.ScrapeEntities[j].ScrapeNewsHTMLElements.ImageAttr[0], func(e *colly.HTMLElement) {
 						newsItem.Image = e.Attr(s.ScrapeEntities[j].ScrapeNewsHTMLElements.ImageAttr[1])
 					})
+
+					if s.ScrapeEntities[j].ScrapeNewsHTMLElements.AuthorTxt != "" {
+						s.Collector.OnHTML(s.ScrapeEntities[j].ScrapeNewsHTMLElements.AuthorTxt, func(e *colly.HTMLElement) {
+							if newsItem.Author == "" {
+								newsItem.Author = strings.TrimSpace(e.Text)
+							}
+						})
+					}
+
+					if len(s.ScrapeEntities[j].ScrapeNewsHTMLElements.GalleryAttr) == 2 {
+						s.Collector.OnHTML(s.ScrapeEntities[j].ScrapeNewsHTMLElements.GalleryAttr[0], func(e *colly.HTMLElement) {
+							src := e.Request.AbsoluteURL(e.Attr(s.ScrapeEntities[j].ScrapeNewsHTMLElements.GalleryAttr[1]))
+							if src != "" {
+								width, _ := strconv.Atoi(e.Attr("width"))
+								height, _ := strconv.Atoi(e.Attr("height"))
+								gallery = append(gallery, galleryImage{src: src, width: width, height: height})
+							}
+						})
+					}
+
+					s.Collector.OnResponse(func(r *colly.Response) {
+						finalUrl = r.Request.URL.String()
+						newsItem.Charset = r.Headers.Get(charset.DetectedHeader)
+						if s.raw != nil {
+							page = s.raw.last(finalUrl)
+						}
+					})
+
+					s.Collector.OnHTML("link[rel=canonical]", func(e *colly.HTMLElement) {
+						// Some templates point the canonical link of every page to the home page, which is not an alias of the article
+						href := e.Request.AbsoluteURL(e.Attr("href"))
+						if cu, err := url.Parse(urlnorm.Canonicalize(href)); err == nil && cu.Path != "" {
+							canonicalUrl = href
+						}
+					})
+
+					s.Collector.OnHTML("meta[name=robots]", func(e *colly.HTMLElement) {
+						robots = e.Attr("content")
+					})
+
+					s.Collector.OnHTML("meta[name=author]", func(e *colly.HTMLElement) {
+						metaAuthor = strings.TrimSpace(e.Attr("content"))
+					})
+
+					s.Collector.OnHTML("script[type=\"application/ld+json\"]", func(e *colly.HTMLElement) {
+						if jsonLDAuthor == "" {
+							jsonLDAuthor = parseJSONLDAuthor(e.Text)
+						}
+					})
 					s.Collector.Visit(newsUrls[i])
 
 					if s.DebugFlag {
@@ -148,9 +328,68 @@ func (s *Scraper) ScrapeNewsFromNewsUrls(newsUrls []string) ([]NewsItem, error)
 
 					time.Sleep(time.Duration(s.ReqSleepMs) * time.Millisecond)
 
+					if finalUrl == "" {
+						finalUrl = newsUrls[i]
+					}
+
+					// Pages rejected below are kept as well, the selectors could be the reason
+					if s.OnSnapshot != nil && page.body != nil {
+						s.OnSnapshot(Snapshot{Url: newsUrls[i], FinalUrl: finalUrl, Source: newsItem.Source, ContentType: page.contentType, FetchedAt: time.Now(), Html: page.body})
+					}
+					checked := pagecheck.Page{Url: finalUrl, Title: newsItem.Title, Posted: newsItem.Posted, Text: newsItem.Text, Robots: robots}
+					if reason := pagecheck.Check(checked, s.ScrapeEntities[j].PageCheck); reason != "" {
+						log.Printf("Skipping %s, the page is not an article: %s", newsUrls[i], reason)
+						rejectedItems = append(rejectedItems, RejectedItem{Item: newsItem, Rejections: []validation.Rejection{{Field: validation.Page, Reason: reason}}})
+						break
+					}
+
+					if newsItem.Author == "" {
+						newsItem.Author = metaAuthor
+					}
+					if newsItem.Author == "" {
+						newsItem.Author = jsonLDAuthor
+					}
+
+					// The url after redirects is stored and posted, the canonical forms of all the urls are only used to deduplicate
+					stripParams := s.ScrapeEntities[j].StripQueryParams
+					newsItem.Aliases = urlnorm.Unique([]string{canonicalUrl, finalUrl, newsUrls[i]}, stripParams...)
+					newsItem.Url = finalUrl
+
+					fields := validation.Fields{Url: newsItem.Url, Title: newsItem.Title, Posted: newsItem.Posted, Image: newsItem.Image, Text: newsItem.Text, PostedLayout: PostedLayout}
+					if rejections := validation.Check(fields, s.ScrapeEntities[j].RequiredFields); len(rejections) > 0 {
+						log.Printf("Skipping %s, the item is not valid: %v", newsItem.Url, rejections)
+						rejectedItems = append(rejectedItems, RejectedItem{Item: newsItem, Rejections: rejections})
+						break
+					}
+
+					newsItem.Images = s.collectImages(newsItem.Image, gallery, s.ScrapeEntities[j].ScrapeNewsHTMLElements.GalleryLimit, s.ScrapeEntities[j].ScrapeNewsHTMLElements.GalleryMinSize)
+
+					newsItem.Category = strings.TrimSpace(newsItem.Category)
+					var matched bool
+					newsItem.NormalizedCategory, matched = s.ScrapeEntities[j].CategoryMapping.Match(newsItem.Category)
+					newsItem.Language = language.Detect(newsItem.Title + "\n" + strings.Join(newsItem.Text, "\n"))
+					// The classifier and the sentiment lexicon only know Russian, other languages get the default category and neutral sentiment
+					isRussian := newsItem.Language == language.Russian
+					newsItem.CategorySource = taxonomy.ByRule
+					if !matched {
+						newsItem.CategorySource = taxonomy.ByDefault
+						if isRussian {
+							s.predictCategory(&newsItem)
+						}
+					}
+					if isRussian {
+						newsItem.Sentiment = sentiment.Score(newsItem.Title, newsItem.Text)
+					}
+					newsItem.Locations = geo.Tag(newsItem.Title, newsItem.Text)
+					if decision := filtering.Evaluate(s.ScrapeEntities[j].Filters, FilterSubject(newsItem)); decision.Rule != "" {
+						newsItem.FilterRule = "source:" + decision.String()
+						log.Printf("Filter rule decision for %s: %s", newsItem.Url, newsItem.FilterRule)
+					}
+
+					// Text can be missing if the source doesn't require it
 					if len(newsItem.Text) > 1 {
 						newsItem.P1 = newsItem.Text[0] + " " + newsItem.Text[1]
-					} else {
+					} else if len(newsItem.Text) == 1 {
 						newsItem.P1 = newsItem.Text[0]
 					}
 					newsItems = append(newsItems, newsItem)
@@ -158,13 +397,138 @@ func (s *Scraper) ScrapeNewsFromNewsUrls(newsUrls []string) ([]NewsItem, error)
 				}
 			}
 		}
-		return newsItems, nil
+		return newsItems, rejectedItems, nil
 	}
 }
 
+func (s *Scraper) collectImages(mainImage string, gallery []galleryImage, limit, minSize int) []string {
+	var images []string
+	seen := make(map[string]bool)
+
+	if mainImage != "" {
+		images = append(images, mainImage)
+		seen[mainImage] = true
+	}
+
+	for _, img := range gallery {
+		if limit > 0 && len(images) >= limit {
+			break
+		}
+		if seen[img.src] {
+			continue
+		}
+		seen[img.src] = true
+
+		if minSize > 0 {
+			width, height := img.width, img.height
+			if width == 0 || height == 0 {
+				var err error
+				width, height, err = s.fetchImageSize(img.src)
+				if err != nil {
+					if s.DebugFlag {
+						log.Printf("DEBUG: can't get the size of the image %s: %v. Skipping it", img.src, err)
+					}
+					continue
+				}
+			}
+			if width < minSize || height < minSize {
+				continue
+			}
+		}
+
+		images = append(images, img.src)
+	}
+
+	return images
+}
+
+// Only the image header is downloaded, which is enough to get the dimensions
+func (s *Scraper) fetchImageSize(src string) (int, int, error) {
+	req, err := http.NewRequest("GET", src, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("User-Agent", s.UserAgent)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	config, _, err := image.DecodeConfig(io.LimitReader(resp.Body, 512*1024))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return config.Width, config.Height, nil
+}
+
+// SourceOf returns the source name of the item, falling back to the host for the items stored without it
+func SourceOf(item NewsItem) string {
+	if item.Source != "" {
+		return item.Source
+	}
+
+	u, err := url.Parse(item.Url)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(u.Host, "www.")
+}
+
+func (s *Scraper) predictCategory(item *NewsItem) {
+	if s.Classifier == nil {
+		return
+	}
+
+	category, probability := s.Classifier.Predict(item.Title, item.Text)
+	if s.DebugFlag {
+		log.Printf("DEBUG: predicted category of %s: %s (%.2f)", item.Url, category, probability)
+	}
+
+	if category != "" && probability >= s.MinClassifierProbability {
+		item.NormalizedCategory = category
+		item.CategorySource = taxonomy.ByClassifier
+	}
+}
+
+func FilterSubject(item NewsItem) filtering.Subject {
+	return filtering.Subject{
+		Title:      item.Title,
+		Text:       item.Text,
+		Categories: []string{item.NormalizedCategory, item.Category},
+		Locations:  item.Locations,
+	}
+}
+
+// FindEntity returns the scrape entity the item came from, or nil if it is not configured anymore
+func FindEntity(entities []ScrapeEntity, item NewsItem) *ScrapeEntity {
+	for i := range entities {
+		if item.Source != "" && item.Source == entities[i].SourceName {
+			return &entities[i]
+		}
+	}
+
+	for i := range entities {
+		if urlnorm.Host(item.Url) == urlnorm.Host(entities[i].SourceUrl) {
+			return &entities[i]
+		}
+	}
+
+	return nil
+}
+
+// Dates without a zone are the local time of the source
 func formatTime(src, inputLayout string, suppressError bool) string {
 
-	t, err := time.Parse(inputLayout, src)
+	t, err := time.ParseInLocation(inputLayout, src, PostedLocation)
 	if err != nil {
 		if !suppressError {
 			fmt.Println("Error parsing timestamp:", err)
@@ -172,20 +536,21 @@ func formatTime(src, inputLayout string, suppressError bool) string {
 		return ""
 	}
 
-	return t.Format("02-01-2006 15:04:05")
+	return FormatPosted(t)
 }
 
-func (s *Scraper) removeDuplicatesAndRootSite(newsUrls []string) []string {
+// The urls are compared by their canonical forms, the first url of each form is kept as is
+func (s *Scraper) removeDuplicatesAndRootSite(newsUrls, canonicalUrls []string) []string {
 	uniqueMap := make(map[string]bool)
 	result := []string{}
 
 	for i := 0; i < len(s.ScrapeEntities); i++ {
-		uniqueMap[s.ScrapeEntities[i].SourceUrl] = true
+		uniqueMap[urlnorm.Canonicalize(s.ScrapeEntities[i].SourceUrl, s.ScrapeEntities[i].StripQueryParams...)] = true
 	}
 
-	for _, url := range newsUrls {
-		if url != "" && !uniqueMap[url] {
-			uniqueMap[url] = true
+	for i, url := range newsUrls {
+		if url != "" && !uniqueMap[canonicalUrls[i]] {
+			uniqueMap[canonicalUrls[i]] = true
 			result = append(result, url)
 		}
 	}
@@ -222,7 +587,7 @@ func parseDateTimeFromTextFallback(inputText, regex, layout, timeFormat string)
 		return "", fmt.Errorf("no date found in the input string")
 	}
 
-	parsedDate, err := time.Parse(layout, matches[0])
+	parsedDate, err := time.ParseInLocation(layout, matches[0], PostedLocation)
 	if err != nil {
 		return "", err
 	}
@@ -230,3 +595,54 @@ func parseDateTimeFromTextFallback(inputText, regex, layout, timeFormat string)
 	formattedDateTime := parsedDate.Format(timeFormat)
 	return formatTime(formattedDateTime, timeFormat, false), nil
 }
+
+// JSON-LD can contain a single object, an array of objects or a @graph, and the author itself can be a string, an object or an array
+func parseJSONLDAuthor(src string) string {
+	var data interface{}
+	if err := json.Unmarshal([]byte(src), &data); err != nil {
+		return ""
+	}
+
+	return findJSONLDAuthor(data)
+}
+
+func findJSONLDAuthor(data interface{}) string {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, element := range v {
+			if author := findJSONLDAuthor(element); author != "" {
+				return author
+			}
+		}
+	case map[string]interface{}:
+		if author, ok := v["author"]; ok {
+			if name := jsonLDName(author); name != "" {
+				return name
+			}
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findJSONLDAuthor(graph)
+		}
+	}
+	return ""
+}
+
+func jsonLDName(author interface{}) string {
+	switch v := author.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok {
+			return strings.TrimSpace(name)
+		}
+	case []interface{}:
+		var names []string
+		for _, element := range v {
+			if name := jsonLDName(element); name != "" {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
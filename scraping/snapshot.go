@@ -0,0 +1,137 @@
+package scraping
+
+import (
+	"bytes"
+	"fmt"
+	"goodnews/charset"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly"
+)
+
+// Snapshot is the page of an article as it was served, before transcoding to UTF-8
+type Snapshot struct {
+	Id                    int
+	Url, FinalUrl, Source string
+	// Content-Type header of the response, the charset is detected from it and the page again when reprocessing
+	ContentType string
+	FetchedAt   time.Time
+	Html        []byte
+}
+
+// Reprocess runs the extraction again on the snapshots instead of fetching the pages, e.g. after a selector was fixed.
+// The charset is detected the same way as for a fetch. Returns the valid items and the rejected ones like ScrapeNewsFromNewsUrls
+func (s *Scraper) Reprocess(snapshots []Snapshot) ([]NewsItem, []RejectedItem, error) {
+	transport := &snapshotTransport{pages: make(map[string]Snapshot)}
+	var newsUrls []string
+	for _, snapshot := range snapshots {
+		transport.pages[snapshot.Url] = snapshot
+		if snapshot.FinalUrl != "" && snapshot.FinalUrl != snapshot.Url {
+			transport.pages[snapshot.FinalUrl] = snapshot
+		}
+		newsUrls = append(newsUrls, snapshot.Url)
+	}
+
+	c := colly.NewCollector()
+	c.UserAgent = s.UserAgent
+	c.AllowURLRevisit = true
+	c.WithTransport(&charset.Transport{Base: transport, Overrides: charsetOverrides(s.ScrapeEntities)})
+
+	reprocessor := *s
+	reprocessor.Collector = c
+	reprocessor.ReqSleepMs = 0
+	reprocessor.OnSnapshot = nil
+	reprocessor.raw = nil
+
+	return reprocessor.ScrapeNewsFromNewsUrls(newsUrls)
+}
+
+// Serves the snapshots, redirecting to the final url as the site did, so the aliases of the items stay the same
+type snapshotTransport struct {
+	pages map[string]Snapshot
+}
+
+func (t *snapshotTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestUrl := req.URL.String()
+	snapshot, ok := t.pages[requestUrl]
+	if !ok {
+		return nil, fmt.Errorf("no snapshot of %s", requestUrl)
+	}
+
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader(snapshot.Html)),
+		Request:    req,
+	}
+
+	if snapshot.FinalUrl != "" && snapshot.FinalUrl != requestUrl {
+		resp.Status, resp.StatusCode = "302 Found", http.StatusFound
+		resp.Header.Set("Location", snapshot.FinalUrl)
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+		return resp, nil
+	}
+
+	if snapshot.ContentType != "" {
+		resp.Header.Set("Content-Type", snapshot.ContentType)
+	}
+	resp.ContentLength = int64(len(snapshot.Html))
+
+	return resp, nil
+}
+
+// Body and Content-Type of a response as the site served them
+type rawPage struct {
+	url, contentType string
+	body             []byte
+}
+
+// Keeps the last response before charset.Transport transcodes it, so the snapshot has the original bytes. The collector fetches one page at a time
+type rawTransport struct {
+	base http.RoundTripper
+	mu   sync.Mutex
+	page rawPage
+}
+
+func (t *rawTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	t.page = rawPage{url: req.URL.String(), contentType: resp.Header.Get("Content-Type"), body: body}
+	t.mu.Unlock()
+
+	return resp, nil
+}
+
+// Returns the last response if it was of the url
+func (t *rawTransport) last(url string) rawPage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.page.url != url {
+		return rawPage{}
+	}
+	return t.page
+}
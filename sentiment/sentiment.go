@@ -0,0 +1,108 @@
+package sentiment
+
+import (
+	"goodnews/nlp"
+	"strings"
+)
+
+// Words within this distance after a negation in the same clause have the opposite polarity: "никто не пострадал"
+const negationWindow = 2
+
+// Title is what readers see first, so it weighs more than a sentence of the text
+const titleWeight = 2
+
+var negations = map[string]bool{"не": true, "нет": true, "ни": true, "без": true, "никто": true, "ничто": true, "никогда": true}
+
+// Word prefixes, which work for all the inflected forms without stemming
+var positive = []string{
+	"спас", "помог", "помощ", "радост", "радов", "счаст", "улыб", "добр", "побед", "успех", "успеш", "подар",
+	"благодар", "любов", "любим", "выздор", "излеч", "вылеч", "рекорд", "чудес", "прекрасн", "красив", "волонтер",
+	"благотвор", "щедр", "замечат", "удивит", "восхит", "вдохнов", "улучш", "праздн", "нашл", "наход", "открыт",
+	"уникальн", "талант", "здоров", "дружб", "друз", "забот", "надежд", "мечт", "смех", "весел", "восстанов",
+	"возрожд", "родил", "рожден", "свадьб", "поддерж", "героизм", "героич", "приют", "новорожд", "гениальн",
+	"изобрел", "изобрет", "награ", "премия", "премии", "тепл", "уют", "милосерд", "сочувств", "бескорыст",
+}
+
+var negative = []string{
+	"убий", "убил", "убит", "смерт", "погиб", "гибел", "умер", "скончал", "войн", "катастроф", "авари",
+	"пожар", "теракт", "террор", "насили", "трагед", "жертв", "ранен", "похорон", "болезн", "эпидем", "пандем",
+	"кризис", "арест", "преступ", "кража", "краж", "украл", "ограбл", "мошен", "взрыв", "обстрел",
+	"бомб", "атак", "угроз", "опасн", "страх", "ужас", "кошмар", "беда", "бедств", "наводнен", "землетряс",
+	"ураган", "крушен", "разбил", "пострадал", "травм", "скандал", "санкци", "протест", "беспоряд", "казн",
+	"пытк", "голод", "нищет", "банкрот", "увольн", "уволил", "суицид", "самоуб", "отравл", "заболел",
+}
+
+// Score returns the positivity of the title and the text from -1 (only negative words) to 1 (only positive words). 0 means neutral
+func Score(title string, text []string) float64 {
+	pos, neg := count(title)
+	pos, neg = pos*titleWeight, neg*titleWeight
+
+	for _, paragraph := range text {
+		p, n := count(paragraph)
+		pos += p
+		neg += n
+	}
+
+	if pos+neg == 0 {
+		return 0
+	}
+
+	return float64(pos-neg) / float64(pos+neg)
+}
+
+func count(text string) (int, int) {
+	pos, neg := 0, 0
+
+	clauses := strings.FieldsFunc(text, func(r rune) bool {
+		return strings.ContainsRune(".,;:!?—()«»\"", r)
+	})
+
+	for _, clause := range clauses {
+		p, n := countClause(clause)
+		pos += p
+		neg += n
+	}
+
+	return pos, neg
+}
+
+func countClause(clause string) (int, int) {
+	pos, neg := 0, 0
+	negatedUntil := -1
+
+	for i, word := range nlp.Words(clause) {
+		if negations[word] {
+			negatedUntil = i + negationWindow
+			continue
+		}
+
+		polarity := 0
+		if hasPrefix(word, positive) {
+			polarity = 1
+		} else if hasPrefix(word, negative) {
+			polarity = -1
+		}
+
+		if i <= negatedUntil {
+			polarity = -polarity
+		}
+
+		switch polarity {
+		case 1:
+			pos++
+		case -1:
+			neg++
+		}
+	}
+
+	return pos, neg
+}
+
+func hasPrefix(word string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
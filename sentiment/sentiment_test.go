@@ -0,0 +1,67 @@
+package sentiment
+
+import "testing"
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		text  []string
+		want  float64
+	}{
+		{
+			name:  "positive",
+			title: "Волонтёры спасли щенка",
+			want:  1,
+		},
+		{
+			name:  "negative",
+			title: "Пожар уничтожил склад",
+			want:  -1,
+		},
+		{
+			name:  "neutral",
+			title: "В городе прошло заседание думы",
+			want:  0,
+		},
+		{
+			name:  "negated negative word",
+			title: "Никто не пострадал",
+			want:  1,
+		},
+		{
+			name:  "negated positive word",
+			title: "Ребёнка не спасли",
+			want:  -1,
+		},
+		{
+			name:  "without",
+			title: "Обошлось без жертв",
+			want:  1,
+		},
+		{
+			name:  "negation ends at the clause",
+			title: "Пожара не было, все спасены",
+			want:  0,
+		},
+		{
+			name:  "negation ends after the window",
+			title: "Не прошло и недели, как погиб",
+			want:  -1,
+		},
+		{
+			name:  "title weighs more than the text",
+			title: "Волонтёры спасли щенка",
+			text:  []string{"Его заметили после пожара."},
+			want:  0.6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Score(tt.title, tt.text); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"goodnews/filtering"
+	"goodnews/pagecheck"
+	"goodnews/scraping"
+	"goodnews/taxonomy"
+)
+
+// Applied to all the sources in addition to their own filters
+var commonFilters = []filtering.Rule{
+	{
+		Name:     "no bad news",
+		Action:   filtering.Block,
+		Keywords: []string{"войн", "погиб", "гибел", "смерт", "скончал", "авари", "катастроф", "теракт"},
+	},
+}
+
+func threshold(value float64) *float64 {
+	return &value
+}
+
+var scrapeEntities = []scraping.ScrapeEntity{
+	{
+		SourceUrl:  "https://positivnews.ru/",
+		SourceName: "Positivnews",
+		ScrapeNewsUrlsElements: scraping.ScrapeNewsURL{
+			UrlElements: []string{"div.digital-newspaper-container", "article.post"},
+		},
+		ScrapeNewsHTMLElements: scraping.ScrapeNewsHTML{
+			TextTxt:        ".entry-content p",
+			CategoryTxt:    ".post-categories a",
+			PostedAttr:     []string{".entry-meta time.updated", "datetime"},
+			PostedFormat:   "2006-01-02T15:04:05-07:00",
+			TitleTxt:       ".entry-title",
+			ImageAttr:      []string{"div.post-inner div.post-thumbnail img.wp-post-image", "src"},
+			GalleryAttr:    []string{".entry-content img", "src"},
+			GalleryLimit:   5,
+			GalleryMinSize: 300,
+		},
+		CategoryMapping: taxonomy.Mapping{
+			Rules: []taxonomy.Rule{
+				{Match: "наука", Category: taxonomy.Science},
+				{Match: "технологии", Category: taxonomy.Technology},
+				{Match: "животные", Category: taxonomy.Animals},
+				{Match: "экология", Category: taxonomy.Nature},
+				{Match: "природа", Category: taxonomy.Nature},
+				{Match: "здоровье", Category: taxonomy.Health},
+				{Match: "медицина", Category: taxonomy.Health},
+				{Match: "добрые дела", Category: taxonomy.Charity},
+				{Match: "благотворительность", Category: taxonomy.Charity},
+				{Match: "общество", Category: taxonomy.Society},
+				{Match: "культура", Category: taxonomy.Culture},
+				{Match: "спорт", Category: taxonomy.Sport},
+				{Match: "открытия", Category: taxonomy.Discoveries},
+			},
+			Default: taxonomy.Other,
+		},
+	},
+	{
+		SourceUrl:  "https://ntdtv.ru/c/pozitivnye-novosti",
+		SourceName: "NTD",
+		ScrapeNewsUrlsElements: scraping.ScrapeNewsURL{
+			UrlElements: []string{"div.entry-image"},
+		},
+		ScrapeNewsHTMLElements: scraping.ScrapeNewsHTML{
+			TextTxt:      "div[id=cont_post] p",
+			CategoryTxt:  "div.entry-meta a[href*=\"/c/\"]",
+			PostedAttr:   []string{"span.entry-date time", "datetime"},
+			PostedFormat: "2006-01-02 15:04:05",
+			TitleTxt:     "header.entry-header h1",
+			ImageAttr:    []string{"link[itemprop=thumbnailUrl]", "href"},
+		},
+		CategoryMapping: taxonomy.Mapping{
+			Rules: []taxonomy.Rule{
+				{Match: `наук|космос`, Regex: true, Category: taxonomy.Science},
+				{Match: `технолог`, Regex: true, Category: taxonomy.Technology},
+				{Match: `живот`, Regex: true, Category: taxonomy.Animals},
+				{Match: `природ|эколог`, Regex: true, Category: taxonomy.Nature},
+				{Match: `здоров|медицин`, Regex: true, Category: taxonomy.Health},
+				{Match: `культур|искусств`, Regex: true, Category: taxonomy.Culture},
+				{Match: `спорт`, Regex: true, Category: taxonomy.Sport},
+				{Match: `обществ`, Regex: true, Category: taxonomy.Society},
+			},
+			// Most of the items are only in the positive news category
+			Default: taxonomy.Society,
+		},
+		// Neutral items score 0, the grim stories of this category are negative
+		MinSentiment: threshold(0),
+		// Category pages of this source are under /c/
+		PageCheck: pagecheck.Config{
+			ListingPatterns: []string{`^https?://[^/]+/c/`},
+		},
+	},
+	{
+		SourceUrl:  "https://allpozitive.ru/",
+		SourceName: "Allpozitive",
+		ScrapeNewsUrlsElements: scraping.ScrapeNewsURL{
+			UrlElements: []string{"div.col-1-2.mq-sidebar div.sb-widget ul.cp-widget.row.clearfix li.cp-wrap.clearfix div.cp-data p.cp-widget-title"},
+		},
+		ScrapeNewsHTMLElements: scraping.ScrapeNewsHTML{
+			TextTxt:        "div.entry.clearfix",
+			CategoryTxt:    "header.post-header p.meta.post-meta a[rel=\"category tag\"]",
+			PostedAttr:     []string{"p.meta.post-meta", "datetime"},
+			PostedFormat:   "2006-01-02T15:04:05-07:00",
+			TitleTxt:       "h1.post-title",
+			ImageAttr:      []string{"div.post-thumbnail img", "src"},
+			GalleryAttr:    []string{"div.entry.clearfix img", "src"},
+			GalleryLimit:   5,
+			GalleryMinSize: 300,
+			PostedTextToParse: scraping.TextToParse{
+				Regex:  `\d{2}\.\d{2}\.\d{4}`,
+				Layout: "02.01.2006",
+			},
+		},
+		CategoryMapping: taxonomy.Mapping{
+			Rules: []taxonomy.Rule{
+				{Match: `наук|космос`, Regex: true, Category: taxonomy.Science},
+				{Match: `технолог|изобрет`, Regex: true, Category: taxonomy.Technology},
+				{Match: `живот|питом|звер`, Regex: true, Category: taxonomy.Animals},
+				{Match: `природ|эколог`, Regex: true, Category: taxonomy.Nature},
+				{Match: `здоров|медицин`, Regex: true, Category: taxonomy.Health},
+				{Match: `добр|помощ|благотвор|волонт`, Regex: true, Category: taxonomy.Charity},
+				{Match: `культур|искусств`, Regex: true, Category: taxonomy.Culture},
+				{Match: `спорт`, Regex: true, Category: taxonomy.Sport},
+			},
+			Default: taxonomy.Other,
+		},
+	},
+}
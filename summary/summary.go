@@ -0,0 +1,140 @@
+package summary
+
+import (
+	"goodnews/nlp"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	damping       = 0.85
+	maxIterations = 50
+	tolerance     = 1e-6
+)
+
+// TextRank returns the highest ranked sentences of the text in their original order, joined together within maxLength bytes.
+// Sentences are ranked by their similarity to the other sentences: https://web.eecs.umich.edu/~mihalcea/papers/mihalcea.emnlp04.pdf
+func TextRank(text []string, maxLength int) string {
+	var sentences []string
+	for _, paragraph := range text {
+		sentences = append(sentences, nlp.Sentences(paragraph)...)
+	}
+
+	if len(sentences) == 0 {
+		return ""
+	}
+
+	terms := make([][]string, len(sentences))
+	for i, sentence := range sentences {
+		terms[i] = nlp.Terms(sentence)
+	}
+
+	scores := rank(similarityMatrix(terms))
+
+	order := make([]int, len(sentences))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	selected := make([]bool, len(sentences))
+	length := 0
+	for _, i := range order {
+		// +1 for the space between the sentences
+		if length+len(sentences[i])+1 > maxLength {
+			continue
+		}
+		selected[i] = true
+		length += len(sentences[i]) + 1
+	}
+
+	var result []string
+	for i, sentence := range sentences {
+		if selected[i] {
+			result = append(result, sentence)
+		}
+	}
+
+	return strings.Join(result, " ")
+}
+
+func similarityMatrix(terms [][]string) [][]float64 {
+	matrix := make([][]float64, len(terms))
+	for i := range matrix {
+		matrix[i] = make([]float64, len(terms))
+	}
+
+	for i := range terms {
+		for j := i + 1; j < len(terms); j++ {
+			similarity := similarity(terms[i], terms[j])
+			matrix[i][j] = similarity
+			matrix[j][i] = similarity
+		}
+	}
+
+	return matrix
+}
+
+// Number of common terms normalised by the sentence lengths, as in the original paper
+func similarity(a, b []string) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+
+	set := make(map[string]bool)
+	for _, term := range a {
+		set[term] = true
+	}
+
+	common := 0
+	seen := make(map[string]bool)
+	for _, term := range b {
+		if set[term] && !seen[term] {
+			common++
+			seen[term] = true
+		}
+	}
+
+	return float64(common) / (math.Log(float64(len(a))) + math.Log(float64(len(b))))
+}
+
+func rank(matrix [][]float64) []float64 {
+	n := len(matrix)
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1
+	}
+
+	weightSums := make([]float64, n)
+	for i := range matrix {
+		for _, weight := range matrix[i] {
+			weightSums[i] += weight
+		}
+	}
+
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		next := make([]float64, n)
+		delta := 0.0
+
+		for i := 0; i < n; i++ {
+			sum := 0.0
+			for j := 0; j < n; j++ {
+				if matrix[j][i] > 0 && weightSums[j] > 0 {
+					sum += matrix[j][i] / weightSums[j] * scores[j]
+				}
+			}
+			next[i] = 1 - damping + damping*sum
+			delta += math.Abs(next[i] - scores[i])
+		}
+
+		scores = next
+		if delta < tolerance {
+			break
+		}
+	}
+
+	return scores
+}
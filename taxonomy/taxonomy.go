@@ -0,0 +1,67 @@
+package taxonomy
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Our own fixed set of categories. Raw categories of every source are mapped to one of them
+const (
+	Science     = "science"
+	Technology  = "technology"
+	Animals     = "animals"
+	Nature      = "nature"
+	Charity     = "charity"
+	Health      = "health"
+	Society     = "society"
+	Culture     = "culture"
+	Sport       = "sport"
+	Discoveries = "discoveries"
+	Other       = "other"
+)
+
+type Rule struct {
+	// Match is compared with the raw category case-insensitively, unless Regex is set
+	Match    string
+	Regex    bool
+	Category string
+}
+
+type Mapping struct {
+	Rules   []Rule
+	Default string
+}
+
+// How the normalised category of an item was set
+const (
+	ByRule       = "rule"
+	ByDefault    = "default"
+	ByClassifier = "classifier"
+)
+
+// Match returns the normalised category and whether it was set by a rule rather than the default bucket
+func (m Mapping) Match(rawCategory string) (string, bool) {
+	raw := strings.ToLower(strings.TrimSpace(rawCategory))
+
+	for _, rule := range m.Rules {
+		if rule.Regex {
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				log.Printf("Error! Category rule regex %s can't be compiled: %v. Proceeding without it", rule.Match, err)
+				continue
+			}
+			if re.MatchString(raw) {
+				return rule.Category, true
+			}
+		} else if raw != "" && raw == strings.ToLower(strings.TrimSpace(rule.Match)) {
+			return rule.Category, true
+		}
+	}
+
+	if m.Default != "" {
+		return m.Default, false
+	}
+
+	return Other, false
+}
@@ -0,0 +1,87 @@
+package translation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Translator translates the texts from the source language to the target one, keeping their order. Languages are the codes of the language package
+type Translator interface {
+	Translate(texts []string, source, target string) ([]string, error)
+}
+
+// NoOp returns the texts as they are
+type NoOp struct{}
+
+func (NoOp) Translate(texts []string, source, target string) ([]string, error) {
+	return texts, nil
+}
+
+// LibreTranslate calls the /translate endpoint of a LibreTranslate-compatible server: https://libretranslate.com/docs
+type LibreTranslate struct {
+	Url, ApiKey string
+	Client      *http.Client
+}
+
+func NewLibreTranslate(url, apiKey string) *LibreTranslate {
+	return &LibreTranslate{
+		Url:    strings.TrimSuffix(url, "/"),
+		ApiKey: apiKey,
+		Client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+type libreTranslateRequest struct {
+	Q      []string `json:"q"`
+	Source string   `json:"source"`
+	Target string   `json:"target"`
+	Format string   `json:"format"`
+	ApiKey string   `json:"api_key,omitempty"`
+}
+
+type libreTranslateResponse struct {
+	TranslatedText []string `json:"translatedText"`
+	Error          string   `json:"error"`
+}
+
+func (t *LibreTranslate) Translate(texts []string, source, target string) ([]string, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(libreTranslateRequest{Q: texts, Source: source, Target: target, Format: "text", ApiKey: t.ApiKey})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.Client.Post(t.Url+"/translate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result libreTranslateResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("unexpected response from %s (status %d): %s", t.Url, resp.StatusCode, respBody)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("translation from %s to %s failed with status %d: %s", source, target, resp.StatusCode, result.Error)
+	}
+
+	if len(result.TranslatedText) != len(texts) {
+		return nil, fmt.Errorf("got %d translations for %d texts", len(result.TranslatedText), len(texts))
+	}
+
+	return result.TranslatedText, nil
+}
@@ -0,0 +1,94 @@
+package translation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNoOp(t *testing.T) {
+	texts := []string{"Волонтёры спасли щенка"}
+	got, err := NoOp{}.Translate(texts, "ru", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, texts) {
+		t.Errorf("got %v, want %v", got, texts)
+	}
+}
+
+func TestLibreTranslate(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "translated",
+			status:   http.StatusOK,
+			response: `{"translatedText": ["Volunteers saved a puppy", "He found a new home"]}`,
+			want:     []string{"Volunteers saved a puppy", "He found a new home"},
+		},
+		{
+			name:     "error status",
+			status:   http.StatusForbidden,
+			response: `{"error": "Invalid API key"}`,
+			wantErr:  true,
+		},
+		{
+			name:     "fewer translations than texts",
+			status:   http.StatusOK,
+			response: `{"translatedText": ["Volunteers saved a puppy"]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "not json",
+			status:   http.StatusBadGateway,
+			response: `<html>Bad Gateway</html>`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request libreTranslateRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/translate" {
+					t.Errorf("got path %s, want /translate", r.URL.Path)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			texts := []string{"Волонтёры спасли щенка", "Он нашёл новый дом"}
+			got, err := NewLibreTranslate(server.URL+"/", "key").Translate(texts, "ru", "en")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			want := libreTranslateRequest{Q: texts, Source: "ru", Target: "en", Format: "text", ApiKey: "key"}
+			if !reflect.DeepEqual(request, want) {
+				t.Errorf("got request %+v, want %+v", request, want)
+			}
+		})
+	}
+}
+
+func TestLibreTranslateWithoutTexts(t *testing.T) {
+	// No request is made, so the url doesn't matter
+	got, err := NewLibreTranslate("http://127.0.0.1:0", "").Translate(nil, "ru", "en")
+	if err != nil || got != nil {
+		t.Errorf("got %v, %v, want no translations and no error", got, err)
+	}
+}
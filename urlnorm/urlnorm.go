@@ -0,0 +1,116 @@
+package urlnorm
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query parameters that never change the article. Names ending with * are prefixes
+var DefaultStripParams = []string{"utm_*", "fbclid", "gclid", "yclid", "ysclid", "_openstat", "amp"}
+
+// Canonicalize brings the url to a single form: https, no www., no fragment, no AMP variant, no trailing slash,
+// no tracking query parameters (DefaultStripParams plus stripParams) and the rest of them sorted.
+// Urls that can't be parsed are returned as is
+func Canonicalize(rawUrl string, stripParams ...string) string {
+	rawUrl = strings.TrimSpace(rawUrl)
+
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return rawUrl
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		u.Scheme = "https"
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	host = strings.TrimPrefix(host, "amp.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.User = nil
+
+	u.Path = canonicalPath(u.Path)
+	u.RawPath = ""
+
+	query := u.Query()
+	for key := range query {
+		if shouldStrip(key, stripParams) {
+			query.Del(key)
+		}
+	}
+	// Encode sorts the parameters by key
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// Host returns the host of the url in the canonical form
+func Host(rawUrl string) string {
+	u, err := url.Parse(Canonicalize(rawUrl))
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func canonicalPath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	// AMP variants are usually /amp/... or .../amp
+	if len(segments) > 1 && segments[len(segments)-1] == "amp" {
+		segments = segments[:len(segments)-1]
+	}
+	if len(segments) > 1 && segments[0] == "amp" {
+		segments = segments[1:]
+	}
+
+	path = strings.Join(segments, "/")
+	if path == "" {
+		return ""
+	}
+
+	return "/" + strings.TrimSuffix(path, ".amp")
+}
+
+func shouldStrip(key string, stripParams []string) bool {
+	key = strings.ToLower(key)
+	params := append(append([]string{}, DefaultStripParams...), stripParams...)
+
+	for _, param := range params {
+		param = strings.ToLower(param)
+		if strings.HasSuffix(param, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(param, "*")) {
+				return true
+			}
+		} else if key == param {
+			return true
+		}
+	}
+	return false
+}
+
+// Unique returns the canonical forms of the urls without duplicates and empty values, keeping the order
+func Unique(urls []string, stripParams ...string) []string {
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, rawUrl := range urls {
+		if rawUrl == "" {
+			continue
+		}
+		canonical := Canonicalize(rawUrl, stripParams...)
+		if !seen[canonical] {
+			seen[canonical] = true
+			result = append(result, canonical)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,107 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Fields of the scraped items
+const (
+	Url   = "url"
+	Title = "title"
+	Text  = "text"
+	Date  = "date"
+	Image = "image"
+	// Rejected by the pagecheck heuristics, not by a field check
+	Page = "page"
+)
+
+// Required fields of the sources which don't set their own. Image is optional, as the items without it get a title card
+var DefaultRequired = []string{Url, Title, Text, Date}
+
+type Rejection struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (r Rejection) String() string {
+	return fmt.Sprintf("%s: %s", r.Field, r.Reason)
+}
+
+// Fields is what is checked on the item. Image can be relative to Url
+type Fields struct {
+	Url, Title, Posted, Image string
+	Text                      []string
+	// Layout Posted is parsed with, scraping.PostedLayout
+	PostedLayout string
+}
+
+// Check returns the rejections for the required fields which are missing or invalid. The item is valid if there are none
+func Check(fields Fields, required []string) []Rejection {
+	if len(required) == 0 {
+		required = DefaultRequired
+	}
+
+	var rejections []Rejection
+
+	for _, field := range required {
+		var reason string
+
+		switch field {
+		case Url:
+			reason = checkUrl(fields.Url)
+		case Title:
+			if strings.TrimSpace(fields.Title) == "" {
+				reason = "missing"
+			}
+		case Text:
+			reason = "missing"
+			for _, paragraph := range fields.Text {
+				if strings.TrimSpace(paragraph) != "" {
+					reason = ""
+					break
+				}
+			}
+		case Date:
+			if fields.Posted == "" {
+				reason = "missing"
+			} else if _, err := time.Parse(fields.PostedLayout, fields.Posted); err != nil {
+				reason = fmt.Sprintf("can't be parsed: %v", err)
+			}
+		case Image:
+			image := strings.TrimSpace(fields.Image)
+			if base, err := url.Parse(fields.Url); err == nil && image != "" {
+				if ref, err := url.Parse(image); err == nil {
+					image = base.ResolveReference(ref).String()
+				}
+			}
+			reason = checkUrl(image)
+		default:
+			reason = "unknown field in the source config"
+		}
+
+		if reason != "" {
+			rejections = append(rejections, Rejection{Field: field, Reason: reason})
+		}
+	}
+
+	return rejections
+}
+
+func checkUrl(raw string) string {
+	if strings.TrimSpace(raw) == "" {
+		return "missing"
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Sprintf("invalid: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Sprintf("not an absolute http(s) url: %q", raw)
+	}
+
+	return ""
+}
@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+const postedLayout = "02-01-2006 15:04:05"
+
+func validFields() Fields {
+	return Fields{
+		Url:          "https://example.com/news/1",
+		Title:        "Волонтёры спасли щенка",
+		Posted:       "19-10-2026 12:00:00",
+		Image:        "/images/1.jpg",
+		Text:         []string{"Волонтёры нашли в лесу щенка."},
+		PostedLayout: postedLayout,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(*Fields)
+		required []string
+		want     []Rejection
+	}{
+		{
+			name:   "valid",
+			change: func(f *Fields) {},
+		},
+		{
+			name:   "missing title and blank text",
+			change: func(f *Fields) { f.Title, f.Text = " ", []string{"", " "} },
+			want:   []Rejection{{Field: Title, Reason: "missing"}, {Field: Text, Reason: "missing"}},
+		},
+		{
+			name:   "relative url",
+			change: func(f *Fields) { f.Url = "/news/1" },
+			want:   []Rejection{{Field: Url, Reason: `not an absolute http(s) url: "/news/1"`}},
+		},
+		{
+			name:   "missing image is optional by default",
+			change: func(f *Fields) { f.Image = "" },
+		},
+		{
+			name:     "missing required image",
+			change:   func(f *Fields) { f.Image = "" },
+			required: []string{Image},
+			want:     []Rejection{{Field: Image, Reason: "missing"}},
+		},
+		{
+			name:     "relative image is resolved against the url",
+			change:   func(f *Fields) {},
+			required: []string{Image},
+		},
+		{
+			name:     "unknown field",
+			change:   func(f *Fields) {},
+			required: []string{Title, "author"},
+			want:     []Rejection{{Field: "author", Reason: "unknown field in the source config"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := validFields()
+			tt.change(&fields)
+			if got := Check(fields, tt.required); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUnparseableDate(t *testing.T) {
+	fields := validFields()
+	fields.Posted = "вчера"
+
+	got := Check(fields, nil)
+	if len(got) != 1 || got[0].Field != Date {
+		t.Errorf("got %v, want a rejection of the date", got)
+	}
+}